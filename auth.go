@@ -0,0 +1,270 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"crypto/md5"
+	sha2562 "crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/RiiConnect24/wiino/golang"
+	"strconv"
+	"time"
+)
+
+const (
+	// DeviceStatusRegistered is the status of an account which may be used.
+	DeviceStatusRegistered = "R"
+	// DeviceStatusUnregistered is the status of an account removed via Unregister.
+	// Its row is kept for its purchase history, and it is reactivated should the console register again.
+	DeviceStatusUnregistered = "D"
+	// DeviceStatusBanned is the status of an account which has been barred from the shop.
+	// Unlike unregistered accounts, it is never reactivated by registering again.
+	DeviceStatusBanned = "B"
+	// DeviceStatusUnknown is reported for consoles which have never registered, or have since unregistered.
+	DeviceStatusUnknown = "U"
+)
+
+var (
+	errInvalidDeviceToken = errors.New("device token does not match any registered account")
+	errDeviceMismatch     = errors.New("device ID does not match the registered account")
+	errAccountInactive    = errors.New("account is no longer registered")
+	errDeviceTokenExpired = errors.New("device token has expired")
+	errUnknownAccount     = errors.New("account does not exist")
+	errInvalidDeviceCode  = errors.New("device code is not a valid Wii Number")
+	errDeviceCodeMismatch = errors.New("device code belongs to another console")
+)
+
+var (
+	// deviceTokenLifetime is how long newly issued device tokens remain valid for, or 0 should they never expire.
+	deviceTokenLifetime time.Duration
+	// deviceTokenRenewalPeriod is how long after expiring device tokens may still be renewed via SyncRegistration.
+	deviceTokenRenewalPeriod time.Duration
+)
+
+// Account represents a registered console, as stored within userbase.
+type Account struct {
+	AccountId  string
+	DeviceId   string
+	DeviceCode string
+	Region     string
+	Country    string
+	Language   string
+	SerialNo   string
+	Status     string
+	// ExtAccountId links this account to an external service, and is usually empty.
+	ExtAccountId string
+	// DeviceTokenIssued is in milliseconds since the epoch.
+	DeviceTokenIssued int64
+	// DeviceTokenExpiry is in milliseconds since the epoch, or 0 should the token never expire.
+	DeviceTokenExpiry int64
+	// DevicePublicKey is the ECC public key from the console's device certificate.
+	DevicePublicKey []byte
+}
+
+// DeviceTokenExpired returns whether this account's device token has expired.
+func (a *Account) DeviceTokenExpired() bool {
+	return a.DeviceTokenExpiry != 0 && a.DeviceTokenExpiry <= time.Now().UnixNano()/int64(time.Millisecond)
+}
+
+// DeviceTokenRenewable returns whether this account's device token may still be renewed via SyncRegistration,
+// as it has either not expired or expired within deviceTokenRenewalPeriod.
+func (a *Account) DeviceTokenRenewable() bool {
+	if !a.DeviceTokenExpired() {
+		return true
+	}
+	return a.DeviceTokenExpiry+int64(deviceTokenRenewalPeriod/time.Millisecond) > time.Now().UnixNano()/int64(time.Millisecond)
+}
+
+// RegistrationStatus returns the status CheckRegistration reports for this account.
+// The Shop only registers consoles reported as unknown, so accounts which must register again are reported as such.
+func (a *Account) RegistrationStatus() string {
+	if a.Status == DeviceStatusUnregistered || (a.Status == DeviceStatusRegistered && !a.DeviceTokenRenewable()) {
+		return DeviceStatusUnknown
+	}
+	return a.Status
+}
+
+// issueDeviceToken generates a new device token for this account, updating its issue and expiry times.
+// It returns the token to send to the console, alongside the hash to store in place of any previous one.
+func (a *Account) issueDeviceToken() (string, string, error) {
+	// Generate a device token, 21 characters...
+	deviceToken, err := generateDeviceToken()
+	if err != nil {
+		return "", "", err
+	}
+	// ...and then its md5, because the Wii sends this...
+	md5DeviceToken := fmt.Sprintf("%x", md5.Sum([]byte(deviceToken)))
+
+	issued := time.Now()
+	a.DeviceTokenIssued = issued.UnixNano() / int64(time.Millisecond)
+	a.DeviceTokenExpiry = 0
+	if deviceTokenLifetime != 0 {
+		a.DeviceTokenExpiry = issued.Add(deviceTokenLifetime).UnixNano() / int64(time.Millisecond)
+	}
+
+	return deviceToken, hashDeviceToken(md5DeviceToken), nil
+}
+
+// renewDeviceToken issues a new device token for an account, replacing the stored hash and so invalidating the previous token.
+func renewDeviceToken(account *Account) (string, error) {
+	deviceToken, deviceTokenHash, err := account.issueDeviceToken()
+	if err != nil {
+		return "", err
+	}
+	err = store.UpdateDeviceToken(*account, deviceTokenHash)
+	if err != nil {
+		return "", err
+	}
+	return deviceToken, nil
+}
+
+// accountIdAttempts is how many account IDs createAccount generates before giving up.
+const accountIdAttempts = 5
+
+// createAccount registers a new account, generating its account ID and device token.
+// Should these collide with another account, new ones are generated. errAccountExists is only returned
+// if the device itself is already registered, as no amount of retrying will help then.
+func createAccount(account *Account) (string, error) {
+	for attempt := 0; attempt < accountIdAttempts; attempt++ {
+		accountId, err := generateAccountId()
+		if err != nil {
+			return "", err
+		}
+		account.AccountId = accountId
+
+		deviceToken, deviceTokenHash, err := account.issueDeviceToken()
+		if err != nil {
+			return "", err
+		}
+
+		err = store.CreateAccount(*account, deviceTokenHash)
+		if err != errAccountExists {
+			return deviceToken, err
+		}
+
+		existing, err := store.GetAccountByDevice(account.DeviceId)
+		if err != nil {
+			return "", err
+		}
+		if existing != nil {
+			return "", errAccountExists
+		}
+	}
+
+	return "", errors.New("failed to generate a unique account ID")
+}
+
+// validateDeviceCode ensures a device code is a valid Wii Number, as shown within the console's address book.
+func validateDeviceCode(deviceCode string) error {
+	userId, err := strconv.ParseUint(deviceCode, 10, 64)
+	if err != nil || wiino.NWC24CheckUserID(userId) != 0 {
+		return errInvalidDeviceCode
+	}
+	return nil
+}
+
+// checkDeviceCodeOwner ensures a valid device code was issued to the console with the given device ID.
+// Wii Numbers embed the console ID they were generated for, alongside a counter which changes should it be formatted.
+func checkDeviceCodeOwner(deviceCode string, deviceId string) error {
+	userId, err := strconv.ParseUint(deviceCode, 10, 64)
+	if err != nil {
+		return errInvalidDeviceCode
+	}
+	consoleId, err := consoleIdFromDeviceId(deviceId)
+	if err != nil || wiino.NWC24GetHollywoodID(userId) != consoleId {
+		return errDeviceCodeMismatch
+	}
+	return nil
+}
+
+// hashDeviceToken returns the value stored in userbase for a device token.
+// The Wii sends the md5 of its device token, so we store the sha256 of that md5 as a string.
+func hashDeviceToken(md5DeviceToken string) string {
+	return fmt.Sprintf("%x", sha2562.Sum256([]byte(md5DeviceToken)))
+}
+
+// authenticate verifies the AccountId and DeviceToken within a request against userbase,
+// returning the registered account they belong to. Expired tokens are accepted so long as they may still be renewed.
+func authenticate(e *Envelope, credentials *AccountRequest) (*Account, error) {
+	account, err := store.GetAccount(credentials.AccountId, hashDeviceToken(credentials.DeviceToken))
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, errInvalidDeviceToken
+	}
+
+	// A token is only valid for the console it was issued to.
+	if account.DeviceId != e.DeviceId() {
+		return nil, errDeviceMismatch
+	}
+	if account.Status != DeviceStatusRegistered {
+		return nil, errAccountInactive
+	}
+	if !account.DeviceTokenRenewable() {
+		return nil, errDeviceTokenExpired
+	}
+
+	return account, nil
+}
+
+// authenticateUnexpired behaves as authenticate, additionally rejecting expired device tokens.
+// Consoles must renew expired tokens via SyncRegistration before using them elsewhere.
+func authenticateUnexpired(e *Envelope, credentials *AccountRequest) (*Account, error) {
+	account, err := authenticate(e, credentials)
+	if err != nil {
+		return nil, err
+	}
+	if account.DeviceTokenExpired() {
+		return nil, errDeviceTokenExpired
+	}
+
+	return account, nil
+}
+
+// revokedDeviceTokenHash returns a value to replace a device token hash with, such that no token will match it.
+// We cannot simply clear the column, as it must remain unique.
+func revokedDeviceTokenHash() (string, error) {
+	unusable, err := randomDigits(32)
+	if err != nil {
+		return "", err
+	}
+	return hashDeviceToken("revoked-" + unusable), nil
+}
+
+// runExpireTokensCommand handles the "expire-tokens" subcommand, such as "WiiSOAP expire-tokens 123456789".
+// The account's device token is revoked rather than merely expired, so it cannot be renewed via SyncRegistration.
+// Affected consoles must instead register again, proving who they are with their device certificate.
+func runExpireTokensCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: WiiSOAP expire-tokens <AccountId>")
+	}
+
+	revokedTokenHash, err := revokedDeviceTokenHash()
+	if err != nil {
+		return err
+	}
+	err = store.RevokeDeviceTokens(args[0], revokedTokenHash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("[i] Revoked device tokens for account " + args[0] + ".")
+	return nil
+}
@@ -0,0 +1,118 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeviceTokenRenewable(t *testing.T) {
+	previous := deviceTokenRenewalPeriod
+	deviceTokenRenewalPeriod = 30 * 24 * time.Hour
+	defer func() { deviceTokenRenewalPeriod = previous }()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	day := int64(24 * time.Hour / time.Millisecond)
+	tests := []struct {
+		expiry    int64
+		renewable bool
+		status    string
+	}{
+		{0, true, DeviceStatusRegistered},
+		{now + day, true, DeviceStatusRegistered},
+		{now - day, true, DeviceStatusRegistered},
+		{now - 31*day, false, DeviceStatusUnknown},
+	}
+
+	for _, test := range tests {
+		account := Account{Status: DeviceStatusRegistered, DeviceTokenExpiry: test.expiry}
+		if account.DeviceTokenRenewable() != test.renewable {
+			t.Errorf("token expiring at %d is renewable: %v, want %v", test.expiry, !test.renewable, test.renewable)
+		}
+		if account.RegistrationStatus() != test.status {
+			t.Errorf("token expiring at %d reports status %s, want %s", test.expiry, account.RegistrationStatus(), test.status)
+		}
+	}
+}
+
+func TestRevokeDeviceTokens(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	err := runExpireTokensCommand([]string{account.AccountId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := store.GetAccountByDevice(account.DeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Consoles must register again, which the Shop only does for unknown consoles.
+	if revoked.RegistrationStatus() != DeviceStatusUnknown {
+		t.Errorf("revoked account reports status %s, want %s", revoked.RegistrationStatus(), DeviceStatusUnknown)
+	}
+
+	err = runExpireTokensCommand([]string{"999999999"})
+	if err != errUnknownAccount {
+		t.Errorf("revoking an unknown account returned %v, want %v", err, errUnknownAccount)
+	}
+}
+
+func TestRevokeDeviceTokensKeepsBans(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+	// Accounts are only ever banned by hand.
+	_, err := store.(*sqlStore).db.Exec(`UPDATE userbase SET Status = ? WHERE AccountId = ?`, DeviceStatusBanned, account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runExpireTokensCommand([]string{account.AccountId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := store.GetAccountByDevice(account.DeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked.Status != DeviceStatusBanned {
+		t.Errorf("revoked banned account has status %s, want %s", revoked.Status, DeviceStatusBanned)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected *ShopError
+	}{
+		{errInvalidDeviceToken, ErrorInvalidDeviceToken},
+		{errDeviceMismatch, ErrorDeviceMismatch},
+		{errAccountInactive, ErrorAccountInactive},
+		{errDeviceTokenExpired, ErrorDeviceTokenExpired},
+		// Failures such as the database being unreachable say nothing about the console's credentials.
+		{errors.New("failed to execute db operation"), ErrorServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		if got := authError(test.err); got != test.expected {
+			t.Errorf("%v is reported with code %d, want %d", test.err, got.Code, test.expected.Code)
+		}
+	}
+}
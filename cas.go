@@ -0,0 +1,97 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	registerService("cas",
+		Action{Name: "ListTitles", Request: ListTitlesRequest{}, Handler: casListTitles},
+		Action{Name: "ListContentSets", Request: ListContentSetsRequest{}, Handler: casListContentSets},
+		Action{Name: "GetTitleDetails", Request: GetTitleDetailsRequest{}, Handler: casGetTitleDetails},
+	)
+}
+
+func casListTitles(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*ListTitlesRequest)
+
+	titles, err := listCatalogTitles(request.Region, request.Country, request.Platform)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	response := ListTitlesResponse{
+		ListResultTotalSize: len(titles),
+	}
+	start, end := request.page(len(titles))
+	for _, title := range titles[start:end] {
+		response.TitleInfos = append(response.TitleInfos, title.TitleInfo())
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func casListContentSets(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*ListContentSetsRequest)
+
+	titles, err := listCatalogTitles(request.Region, request.Country, request.Platform)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	response := ListContentSetsResponse{
+		ListResultTotalSize: len(titles),
+	}
+	start, end := request.page(len(titles))
+	for _, title := range titles[start:end] {
+		response.ContentSets = append(response.ContentSets, ContentSets{
+			TitleId: fmt.Sprintf("%016x", title.TitleId),
+			Version: title.Version,
+			FsSize:  title.Size,
+		})
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func casGetTitleDetails(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*GetTitleDetailsRequest)
+
+	titleId, err := parseTitleId(request.TitleId)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidRequest, err)
+	}
+
+	title, err := store.GetCatalogTitle(titleId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+	if title == nil {
+		return e.ReturnError(ErrorTitleNotFound, errors.New("title is not within the catalog"))
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(GetTitleDetailsResponse{
+		TitleInfo: title.TitleInfo(),
+	})
+}
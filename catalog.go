@@ -0,0 +1,115 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CatalogTitle represents a title offered within the shop.
+type CatalogTitle struct {
+	TitleId uint64
+	Name    string
+	Price   int
+	Region  string
+	// Countries limits availability within the region. If empty, all countries within the region are permitted.
+	Countries []string
+	Platform  string
+	Version   uint16
+	// ReleaseDate is in milliseconds since the epoch.
+	ReleaseDate int64
+	Size        int64
+	// Ratings are in the form of "ESRB:E", one per rating board.
+	Ratings []string
+}
+
+// AvailableIn returns whether this title may be sold within the given region and country.
+func (c *CatalogTitle) AvailableIn(region string, country string) bool {
+	if c.Region != region {
+		return false
+	}
+	if len(c.Countries) == 0 {
+		return true
+	}
+
+	for _, permitted := range c.Countries {
+		if permitted == country {
+			return true
+		}
+	}
+	return false
+}
+
+// TitleInfo returns the TitleInfo structure describing this title.
+func (c *CatalogTitle) TitleInfo() TitleInfo {
+	info := TitleInfo{
+		TitleId:     fmt.Sprintf("%016x", c.TitleId),
+		TitleName:   c.Name,
+		Platform:    c.Platform,
+		Version:     c.Version,
+		ReleaseDate: c.ReleaseDate,
+		TitleSize:   c.Size,
+		Price: Price{
+			Amount:   c.Price,
+			Currency: "POINTS",
+		},
+	}
+
+	for _, rating := range c.Ratings {
+		parts := strings.SplitN(rating, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		info.Ratings = append(info.Ratings, Rating{
+			Name:  parts[0],
+			Value: parts[1],
+		})
+	}
+
+	return info
+}
+
+// listCatalogTitles returns titles available within a region and country, optionally limited to a platform.
+func listCatalogTitles(region string, country string, platform string) ([]CatalogTitle, error) {
+	titles, err := store.ListCatalogTitles(region, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	// Country availability is stored as a list, so we filter here.
+	var available []CatalogTitle
+	for _, title := range titles {
+		if title.AvailableIn(region, country) {
+			available = append(available, title)
+		}
+	}
+	return available, nil
+}
+
+// splitList interprets a comma-separated list, as stored within the database.
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,172 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const (
+	// SignatureRSA4096 is the signature type of a certificate signed with an RSA-4096 key over SHA-1.
+	SignatureRSA4096 = 0x00010000
+	// SignatureECC is the signature type of a certificate signed with an ECC B-233 key over SHA-1.
+	SignatureECC = 0x00010002
+
+	// KeyTypeRSA4096 is the key type of a certificate containing an RSA-4096 public key.
+	KeyTypeRSA4096 = 0
+	// KeyTypeRSA2048 is the key type of a certificate containing an RSA-2048 public key.
+	KeyTypeRSA2048 = 1
+	// KeyTypeECC is the key type of a certificate containing an ECC B-233 public key.
+	KeyTypeECC = 2
+)
+
+// Certificate describes a single certificate, such as those within a certificate chain or a console's device certificate.
+type Certificate struct {
+	SignatureType uint32
+	Signature     []byte
+	Issuer        string
+	KeyType       uint32
+	Name          string
+	KeyId         uint32
+	// PublicKey is either an RSA modulus followed by its 4 byte exponent, or an ECC public key.
+	PublicKey []byte
+
+	// signed is the portion of the certificate covered by its signature, beginning with its issuer.
+	signed []byte
+}
+
+// parseCertificate reads the certificate at the start of contents, returning it alongside its length.
+func parseCertificate(contents []byte) (*Certificate, int, error) {
+	if len(contents) < 4 {
+		return nil, 0, errors.New("truncated certificate")
+	}
+	cert := &Certificate{SignatureType: binary.BigEndian.Uint32(contents[0:4])}
+
+	// The signature and public key both vary in length based on their type.
+	var signatureLength, length int
+	switch cert.SignatureType {
+	case SignatureRSA4096:
+		// RSA-4096, with padding.
+		signatureLength = 0x200
+		length = 4 + 0x200 + 0x3C
+	case SignatureRSA2048:
+		// RSA-2048, with padding.
+		signatureLength = 0x100
+		length = 4 + 0x100 + 0x3C
+	case SignatureECC:
+		// ECC B-233, with padding.
+		signatureLength = 0x3C
+		length = 4 + 0x3C + 0x40
+	default:
+		return nil, 0, errors.New("unknown certificate signature type")
+	}
+
+	// The issuer is followed by the key type, the certificate's name and key ID.
+	if len(contents) < length+0x88 {
+		return nil, 0, errors.New("truncated certificate")
+	}
+	cert.Signature = contents[4 : 4+signatureLength]
+	signedOffset := length
+	cert.Issuer = string(bytes.TrimRight(contents[length:length+0x40], "\x00"))
+	cert.KeyType = binary.BigEndian.Uint32(contents[length+0x40 : length+0x44])
+	cert.Name = string(bytes.TrimRight(contents[length+0x44:length+0x84], "\x00"))
+	cert.KeyId = binary.BigEndian.Uint32(contents[length+0x84 : length+0x88])
+	length += 0x88
+
+	var keyLength int
+	switch cert.KeyType {
+	case KeyTypeRSA4096:
+		// RSA-4096 modulus and exponent, with padding.
+		keyLength = 0x200 + 4
+		length += 0x200 + 4 + 0x34
+	case KeyTypeRSA2048:
+		// RSA-2048 modulus and exponent, with padding.
+		keyLength = 0x100 + 4
+		length += 0x100 + 4 + 0x34
+	case KeyTypeECC:
+		// ECC B-233 public key, with padding.
+		keyLength = 0x3C
+		length += 0x3C + 0x3C
+	default:
+		return nil, 0, errors.New("unknown certificate key type")
+	}
+
+	if len(contents) < length {
+		return nil, 0, errors.New("truncated certificate")
+	}
+	cert.PublicKey = contents[signedOffset+0x88 : signedOffset+0x88+keyLength]
+	cert.signed = contents[signedOffset:length]
+
+	return cert, length, nil
+}
+
+// splitCertificates separates a concatenated certificate chain into its individual certificates.
+func splitCertificates(contents []byte) ([][]byte, error) {
+	var certs [][]byte
+
+	for len(contents) > 0 {
+		_, length, err := parseCertificate(contents)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, contents[:length])
+		contents = contents[length:]
+	}
+
+	return certs, nil
+}
+
+// FullName returns the name certificates signed by this certificate list as their issuer, such as Root-CA00000001.
+func (c *Certificate) FullName() string {
+	return c.Issuer + "-" + c.Name
+}
+
+// verifySignedBy checks that this certificate was issued and signed by the given certificate.
+func (c *Certificate) verifySignedBy(issuer *Certificate) error {
+	if c.Issuer != issuer.FullName() {
+		return errors.New("certificate was not issued by " + issuer.FullName())
+	}
+
+	hash := sha1.Sum(c.signed)
+	switch c.SignatureType {
+	case SignatureRSA4096, SignatureRSA2048:
+		if issuer.KeyType != KeyTypeRSA4096 && issuer.KeyType != KeyTypeRSA2048 {
+			return errors.New("certificate signature does not match its issuer's key type")
+		}
+		modulus := issuer.PublicKey[:len(issuer.PublicKey)-4]
+		exponent := binary.BigEndian.Uint32(issuer.PublicKey[len(issuer.PublicKey)-4:])
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(exponent)}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA1, hash[:], c.Signature)
+	case SignatureECC:
+		if issuer.KeyType != KeyTypeECC {
+			return errors.New("certificate signature does not match its issuer's key type")
+		}
+		if !verifyECDSA(issuer.PublicKey, hash[:], c.Signature) {
+			return errors.New("certificate signature is invalid")
+		}
+		return nil
+	default:
+		return errors.New("unknown certificate signature type")
+	}
+}
@@ -0,0 +1,131 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// contentPrefix is the path all content downloads are served under.
+const contentPrefix = "/ccs/download/"
+
+// contentPath is the directory contents are stored within, as <titleid>/<file>.
+var contentPath string
+
+// contentFileParse matches the files a console may request for a title:
+// its TMD (optionally for a specific version), its common ticket, or an encrypted content.
+var contentFileParse = regexp.MustCompile(`^(tmd(\.[0-9]+)?|cetk|[0-9a-f]{8})$`)
+
+// loadContentStore verifies the configured content store exists.
+func loadContentStore(config Config) error {
+	stat, err := os.Stat(config.ContentPath)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("content path %s is not a directory", config.ContentPath)
+	}
+
+	contentPath = config.ContentPath
+	return nil
+}
+
+// contentHandler serves title contents from the content store, such as /ccs/download/0001000148414445/tmd.
+func contentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, contentPrefix), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	titleIdString := strings.ToLower(parts[0])
+	file := strings.ToLower(parts[1])
+
+	// Validating both components also ensures we cannot be asked for paths outside of the content store.
+	titleId, err := parseTitleId(titleIdString)
+	if err != nil || !contentFileParse.MatchString(file) {
+		http.NotFound(w, r)
+		return
+	}
+
+	allowed, err := contentAccessible(titleId, file)
+	if err != nil {
+		http.Error(w, "Error checking title access.", http.StatusInternalServerError)
+		fmt.Println("Failed to check content access: " + err.Error())
+		return
+	}
+	if !allowed {
+		http.Error(w, "This title is not available.", http.StatusForbidden)
+		return
+	}
+
+	contents, err := os.Open(filepath.Join(contentPath, titleIdString, file))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "Error reading content.", http.StatusInternalServerError)
+		fmt.Println("Failed to open content: " + err.Error())
+		return
+	}
+	defer contents.Close()
+
+	stat, err := contents.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// ServeContent handles Content-Length and range requests for us.
+	fmt.Println("[!] Serving content " + titleIdString + "/" + file)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, file, stat.ModTime(), contents)
+}
+
+// contentAccessible returns whether a file for the given title may be downloaded.
+// System titles are always available. Other titles must have been issued to an account,
+// and their common ticket is never served as consoles receive personalised tickets via ECS.
+func contentAccessible(titleId uint64, file string) (bool, error) {
+	if isSystemTitle(fmt.Sprintf("%016x", titleId)) {
+		return true, nil
+	}
+	if file == "cetk" {
+		return false, nil
+	}
+
+	return store.IsTitleOwned(titleId)
+}
+
+// isSystemTitle returns whether a title is a system title within any region.
+func isSystemTitle(titleId string) bool {
+	for region := range systemTitles {
+		if findSystemTitle(region, titleId) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// deviceCertSize is the length of a console's device certificate, signed by the MS with ECC.
+const deviceCertSize = 0x180
+
+var errDeviceCertMismatch = errors.New("device certificate does not belong to this device")
+
+// msCert signs the device certificate of every console, and is itself signed by the CA within certChain.
+var msCert *Certificate
+
+// loadDeviceCertIssuer reads the MS certificate from the config, verifying it against the certificate chain.
+// It must be called after loadTicketKeys.
+func loadDeviceCertIssuer(config Config) error {
+	contents, err := ioutil.ReadFile(config.MSCert)
+	if err != nil {
+		return err
+	}
+	cert, length, err := parseCertificate(contents)
+	if err != nil {
+		return err
+	}
+	if length != len(contents) {
+		return errors.New("MS certificate must contain a single certificate")
+	}
+	if cert.KeyType != KeyTypeECC {
+		return errors.New("MS certificate must contain an ECC public key")
+	}
+
+	for _, raw := range certChain {
+		ca, _, err := parseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if ca.FullName() != cert.Issuer {
+			continue
+		}
+
+		err = cert.verifySignedBy(ca)
+		if err != nil {
+			return fmt.Errorf("MS certificate could not be verified: %v", err)
+		}
+		msCert = cert
+		return nil
+	}
+
+	return errors.New("certificate chain does not contain the MS certificate's issuer " + cert.Issuer)
+}
+
+// verifyDeviceCert checks that a device certificate was signed by the MS and belongs to the given device ID.
+// It returns the console's public key.
+func verifyDeviceCert(contents []byte, deviceId string) ([]byte, error) {
+	if len(contents) != deviceCertSize {
+		return nil, errors.New("device certificate has an unexpected length")
+	}
+	cert, _, err := parseCertificate(contents)
+	if err != nil {
+		return nil, err
+	}
+	if cert.SignatureType != SignatureECC || cert.KeyType != KeyTypeECC {
+		return nil, errors.New("device certificate is not an ECC certificate")
+	}
+
+	// Device certificates are named after the lower 32 bits of the device ID, such as NG0402a1f3.
+	consoleId, err := consoleIdFromDeviceId(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(cert.Name, fmt.Sprintf("NG%08x", consoleId)) {
+		return nil, errDeviceCertMismatch
+	}
+
+	err = cert.verifySignedBy(msCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return cert.PublicKey, nil
+}
@@ -0,0 +1,94 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+// testdata/devicecert contains an MS certificate and a device certificate for console 0402503a signed by it,
+// using the keys within ecc_test.go. The MS certificate's own signature is not populated.
+const testDeviceId = "4362227770"
+
+// setupDeviceCertIssuer installs the test MS certificate, returning a function restoring the previous one.
+func setupDeviceCertIssuer(t *testing.T) func() {
+	cert, _, err := parseCertificate(readTestFile(t, "testdata/devicecert/MS00000002.cert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := msCert
+	msCert = cert
+	return func() {
+		msCert = previous
+	}
+}
+
+func readTestFile(t *testing.T, path string) []byte {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestVerifyDeviceCert(t *testing.T) {
+	defer setupDeviceCertIssuer(t)()
+	contents := readTestFile(t, "testdata/devicecert/NG0402503a.cert")
+
+	publicKey, err := verifyDeviceCert(contents, testDeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(publicKey); got != testNGPublicKey {
+		t.Errorf("public key is %s, want %s", got, testNGPublicKey)
+	}
+}
+
+func TestVerifyDeviceCertRejects(t *testing.T) {
+	defer setupDeviceCertIssuer(t)()
+	contents := readTestFile(t, "testdata/devicecert/NG0402503a.cert")
+
+	// Device certificates are only valid for the console they name.
+	_, err := verifyDeviceCert(contents, "4362227771")
+	if err != errDeviceCertMismatch {
+		t.Errorf("certificate for another device gave %v, want %v", err, errDeviceCertMismatch)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"signature", 0x10},
+		{"issuer", 0x80},
+		{"public key", 0x80 + 0x88},
+	}
+	for _, test := range tests {
+		tampered := append([]byte(nil), contents...)
+		tampered[test.offset] ^= 0x01
+		if _, err := verifyDeviceCert(tampered, testDeviceId); err == nil {
+			t.Errorf("certificate with a modified %s was accepted", test.name)
+		}
+	}
+
+	if _, err := verifyDeviceCert(contents[:len(contents)-1], testDeviceId); err == nil {
+		t.Error("truncated certificate was accepted")
+	}
+}
@@ -0,0 +1,225 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eCardCodeLength is the number of digits within a Wii Points Card code, including its check digit.
+const eCardCodeLength = 16
+
+var (
+	errInvalidECardCode = errors.New("not a valid card code")
+	errECardRedeemed    = errors.New("card has already been redeemed")
+	errECardExpired     = errors.New("card has expired")
+
+	// eCardKey keys the hashes of card codes, so that codes cannot be guessed from a database alone.
+	eCardKey []byte
+)
+
+// ECard is a Wii Points Card. Only a hash of its code keyed with ECardKey is stored,
+// so codes cannot be recovered from a leaked database unless the key leaks as well.
+type ECard struct {
+	CodeHash string
+	Value    int
+	// Currency is always POINTS for now, but recorded should other kinds of cards be issued.
+	Currency string
+	// Expiry is in milliseconds since the epoch, or 0 should the card never expire.
+	Expiry int64
+	// RedeemedBy is the account which redeemed this card, or empty if it has not been redeemed.
+	RedeemedBy string
+	// RedeemedAt is in milliseconds since the epoch.
+	RedeemedAt int64
+}
+
+// Expired returns whether this card can no longer be redeemed.
+func (c *ECard) Expired() bool {
+	return c.Expiry != 0 && c.Expiry <= time.Now().UnixNano()/int64(time.Millisecond)
+}
+
+// normaliseECardCode removes the separators users may type within a code, such as "1234-5678-...",
+// returning errInvalidECardCode if what remains is not a code with a valid check digit.
+func normaliseECardCode(code string) (string, error) {
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != eCardCodeLength || strings.Trim(code, digitCharacters) != "" {
+		return "", errInvalidECardCode
+	}
+	if luhnCheckDigit(code[:eCardCodeLength-1]) != code[eCardCodeLength-1] {
+		return "", errInvalidECardCode
+	}
+	return code, nil
+}
+
+// loadECardKey reads the key used to hash card codes from the config.
+func loadECardKey(config Config) error {
+	var err error
+	eCardKey, err = hex.DecodeString(config.ECardKey)
+	if err != nil {
+		return err
+	}
+	if len(eCardKey) < 32 {
+		return errors.New("ECard key must be at least 32 bytes")
+	}
+	// A key of zeros is a placeholder rather than a secret, however long it is.
+	if bytes.Count(eCardKey, []byte{0}) == len(eCardKey) {
+		return errors.New("ECard key must not be all zeros")
+	}
+	return nil
+}
+
+// hashECardCode returns the value stored within ecards for a normalised code.
+// Codes only have 15 random digits, so an unkeyed hash could simply be brute forced.
+func hashECardCode(code string) string {
+	mac := hmac.New(sha256.New, eCardKey)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// luhnCheckDigit returns the digit which makes the given digits pass the Luhn algorithm, catching most typos.
+func luhnCheckDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		// Every other digit, starting with the rightmost, is doubled.
+		if (len(digits)-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// generateECardCode returns a random card code, including its check digit.
+func generateECardCode() (string, error) {
+	digits, err := randomDigits(eCardCodeLength - 1)
+	if err != nil {
+		return "", err
+	}
+	return digits + string(luhnCheckDigit(digits)), nil
+}
+
+// formatECardCode splits a code into groups of four digits, as printed on cards.
+func formatECardCode(code string) string {
+	var groups []string
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:i+4])
+	}
+	return strings.Join(groups, "-")
+}
+
+// findECard returns the card for a code typed by a user, ensuring it may still be redeemed.
+func findECard(code string) (*ECard, error) {
+	code, err := normaliseECardCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := store.GetECard(hashECardCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if card == nil || card.Currency != "POINTS" {
+		return nil, errInvalidECardCode
+	}
+	if card.RedeemedBy != "" {
+		return nil, errECardRedeemed
+	}
+	if card.Expired() {
+		return nil, errECardExpired
+	}
+
+	return card, nil
+}
+
+// redeemECard credits an account with a card's value, returning the ledger entry recording it.
+func redeemECard(accountId string, card *ECard) (*LedgerEntry, error) {
+	entry, err := newLedgerEntry(accountId, TransactionRedeemECard, card.Value, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.RedeemECard(card.CodeHash, *entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// runGenerateECardsCommand handles the "generate-ecards" subcommand, such as "WiiSOAP generate-ecards 10 1000 30".
+// It mints the given number of cards worth the given points, optionally expiring after a number of days,
+// printing their codes. Codes cannot be recovered afterwards, as only their keyed hashes are stored.
+func runGenerateECardsCommand(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errors.New("usage: WiiSOAP generate-ecards <count> <points> [expiry days]")
+	}
+
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count <= 0 {
+		return errors.New("count must be a positive number")
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil || value <= 0 {
+		return errors.New("points must be a positive number")
+	}
+	var expiry int64
+	if len(args) == 3 {
+		days, err := strconv.Atoi(args[2])
+		if err != nil || days <= 0 {
+			return errors.New("expiry days must be a positive number")
+		}
+		expiry = time.Now().Add(time.Duration(days)*24*time.Hour).UnixNano() / int64(time.Millisecond)
+	}
+
+	codes := make([]string, count)
+	cards := make([]ECard, count)
+	for i := range cards {
+		codes[i], err = generateECardCode()
+		if err != nil {
+			return err
+		}
+		cards[i] = ECard{
+			CodeHash: hashECardCode(codes[i]),
+			Value:    value,
+			Currency: "POINTS",
+			Expiry:   expiry,
+		}
+	}
+
+	err = store.AddECards(cards)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		fmt.Println(formatECardCode(code))
+	}
+	return nil
+}
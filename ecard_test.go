@@ -0,0 +1,47 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadECardKey(t *testing.T) {
+	previous := eCardKey
+	defer func() { eCardKey = previous }()
+
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"", false},
+		{"not hex", false},
+		{strings.Repeat("01", 16), false},
+		{strings.Repeat("00", 32), false},
+		{strings.Repeat("01", 32), true},
+		{strings.Repeat("01", 64), true},
+	}
+
+	for _, test := range tests {
+		err := loadECardKey(Config{ECardKey: test.key})
+		if (err == nil) != test.valid {
+			t.Errorf("loading %q returned %v, want valid: %v", test.key, err, test.valid)
+		}
+	}
+}
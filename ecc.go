@@ -0,0 +1,217 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"math/big"
+)
+
+// The Wii signs device certificates using ECDSA over sect233r1, a curve over GF(2^233)
+// which Go's crypto/elliptic does not support. Only what is necessary to verify signatures is implemented here.
+// Field elements are polynomials over GF(2), represented as a big.Int with one bit per coefficient.
+
+// eccSize is the length of a field element or signature component in bytes.
+const eccSize = 30
+
+// eccHex decodes a hexadecimal curve parameter.
+func eccHex(value string) *big.Int {
+	result, ok := new(big.Int).SetString(value, 16)
+	if !ok {
+		panic("invalid curve parameter " + value)
+	}
+	return result
+}
+
+// sect233r1 parameters, as specified within SEC 2.
+var (
+	// eccPolynomial is the reduction polynomial, x^233 + x^74 + 1.
+	eccPolynomial = new(big.Int).SetBit(new(big.Int).SetBit(big.NewInt(1), 74, 1), 233, 1)
+	eccA          = big.NewInt(1)
+	eccB          = eccHex("0066647ede6c332c7f8c0923bb58213b333b20e9ce4281fe115f7d8f90ad")
+	eccG          = &eccPoint{
+		x: eccHex("00fac9dfcbac8313bb2139f1bb755fef65bc391f8b36f8f8eb7371fd558b"),
+		y: eccHex("01006a08a41903350678e58528bebf8a0beff867a7ca36716f7e01f81052"),
+	}
+	// eccN is the order of eccG.
+	eccN = eccHex("01000000000000000000000000000013e974e72f8a6922031d2603cfe0d7")
+)
+
+// eccPoint is a point on sect233r1. The point at infinity is represented by nil.
+type eccPoint struct {
+	x, y *big.Int
+}
+
+// gfAdd returns a + b, which over GF(2) is their exclusive or.
+func gfAdd(a, b *big.Int) *big.Int {
+	return new(big.Int).Xor(a, b)
+}
+
+// gfMul returns a * b, reduced by the field polynomial.
+func gfMul(a, b *big.Int) *big.Int {
+	result := new(big.Int)
+	shifted := new(big.Int)
+	for i := 0; i < b.BitLen(); i++ {
+		if b.Bit(i) == 1 {
+			result.Xor(result, shifted.Lsh(a, uint(i)))
+		}
+	}
+	return gfReduce(result)
+}
+
+// gfReduce returns a modulo the field polynomial.
+func gfReduce(a *big.Int) *big.Int {
+	result := new(big.Int).Set(a)
+	shifted := new(big.Int)
+	degree := eccPolynomial.BitLen()
+	for result.BitLen() >= degree {
+		result.Xor(result, shifted.Lsh(eccPolynomial, uint(result.BitLen()-degree)))
+	}
+	return result
+}
+
+// gfInverse returns the multiplicative inverse of a non-zero a, using the extended Euclidean algorithm.
+func gfInverse(a *big.Int) *big.Int {
+	u := new(big.Int).Set(a)
+	v := new(big.Int).Set(eccPolynomial)
+	g1 := big.NewInt(1)
+	g2 := new(big.Int)
+	shifted := new(big.Int)
+
+	// Throughout, g1 * a = u and g2 * a = v modulo the field polynomial.
+	for u.BitLen() > 1 {
+		j := u.BitLen() - v.BitLen()
+		if j < 0 {
+			u, v = v, u
+			g1, g2 = g2, g1
+			j = -j
+		}
+		u.Xor(u, shifted.Lsh(v, uint(j)))
+		g1.Xor(g1, shifted.Lsh(g2, uint(j)))
+	}
+	return gfReduce(g1)
+}
+
+// onCurve returns whether p satisfies y^2 + xy = x^3 + ax^2 + b.
+func (p *eccPoint) onCurve() bool {
+	if p == nil {
+		return true
+	}
+	x2 := gfMul(p.x, p.x)
+	left := gfAdd(gfMul(p.y, p.y), gfMul(p.x, p.y))
+	right := gfAdd(gfAdd(gfMul(x2, p.x), gfMul(eccA, x2)), eccB)
+	return left.Cmp(right) == 0
+}
+
+// eccDouble returns 2p.
+func eccDouble(p *eccPoint) *eccPoint {
+	if p == nil || p.x.Sign() == 0 {
+		return nil
+	}
+
+	// λ = x + y/x
+	lambda := gfAdd(p.x, gfMul(p.y, gfInverse(p.x)))
+	// x' = λ^2 + λ + a
+	x := gfAdd(gfAdd(gfMul(lambda, lambda), lambda), eccA)
+	// y' = x^2 + (λ + 1)x'
+	y := gfAdd(gfMul(p.x, p.x), gfMul(gfAdd(lambda, big.NewInt(1)), x))
+	return &eccPoint{x: x, y: y}
+}
+
+// eccAdd returns p + q.
+func eccAdd(p, q *eccPoint) *eccPoint {
+	if p == nil {
+		return q
+	}
+	if q == nil {
+		return p
+	}
+	if p.x.Cmp(q.x) == 0 {
+		if p.y.Cmp(q.y) == 0 {
+			return eccDouble(p)
+		}
+		// q is -p, as the negation of (x, y) is (x, x + y).
+		return nil
+	}
+
+	// λ = (y1 + y2)/(x1 + x2)
+	sumX := gfAdd(p.x, q.x)
+	lambda := gfMul(gfAdd(p.y, q.y), gfInverse(sumX))
+	// x3 = λ^2 + λ + x1 + x2 + a
+	x := gfAdd(gfAdd(gfAdd(gfMul(lambda, lambda), lambda), sumX), eccA)
+	// y3 = λ(x1 + x3) + x3 + y1
+	y := gfAdd(gfAdd(gfMul(lambda, gfAdd(p.x, x)), x), p.y)
+	return &eccPoint{x: x, y: y}
+}
+
+// eccMultiply returns kp.
+func eccMultiply(p *eccPoint, k *big.Int) *eccPoint {
+	var result *eccPoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = eccDouble(result)
+		if k.Bit(i) == 1 {
+			result = eccAdd(result, p)
+		}
+	}
+	return result
+}
+
+// parseECCPoint reads a public key stored as its x and y coordinates, returning nil if it is not on the curve.
+func parseECCPoint(publicKey []byte) *eccPoint {
+	if len(publicKey) != eccSize*2 {
+		return nil
+	}
+
+	p := &eccPoint{
+		x: new(big.Int).SetBytes(publicKey[:eccSize]),
+		y: new(big.Int).SetBytes(publicKey[eccSize:]),
+	}
+	if p.x.BitLen() >= eccPolynomial.BitLen() || p.y.BitLen() >= eccPolynomial.BitLen() || !p.onCurve() {
+		return nil
+	}
+	return p
+}
+
+// verifyECDSA returns whether signature, stored as r followed by s, is valid for the given hash and public key.
+func verifyECDSA(publicKey []byte, hash []byte, signature []byte) bool {
+	q := parseECCPoint(publicKey)
+	if q == nil || len(signature) != eccSize*2 {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:eccSize])
+	s := new(big.Int).SetBytes(signature[eccSize:])
+	if r.Sign() <= 0 || r.Cmp(eccN) >= 0 || s.Sign() <= 0 || s.Cmp(eccN) >= 0 {
+		return false
+	}
+
+	// Hashes used by the Wii are never longer than eccN, so need no truncation.
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(s, eccN)
+	u1 := new(big.Int).Mul(e, w)
+	u1.Mod(u1, eccN)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, eccN)
+
+	point := eccAdd(eccMultiply(eccG, u1), eccMultiply(q, u2))
+	if point == nil {
+		return false
+	}
+
+	v := new(big.Int).Mod(point.x, eccN)
+	return v.Cmp(r) == 0
+}
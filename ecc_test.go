@@ -0,0 +1,168 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// These vectors were generated by OpenSSL on sect233r1 with ECDSA over SHA-1, independently of ecc.go.
+// The same keys back the certificates within testdata/devicecert.
+const (
+	testMSPrivateKey = "00aea057e05b8eed57c716fcae793d11f24ca65fccdb29b7ce5a14652028"
+	testMSPublicKey  = "00062077400d89250566deb22954d99f742d3903201782e61776125b7cb4" +
+		"00592bb597fe1424e745ed27eed56ae28670ba8123339bd6d2d85b315a25"
+	testNGPrivateKey = "00500a35cbb283e6d4f0f433dce74b4f04c29bcbb409bc46c515abc69624"
+	testNGPublicKey  = "01540f885f2458d3c6c1e7d5b799914bf2d81db640b673dd78a87d386708" +
+		"0164cb11680fef17d2def15893f78438564c738cc878c2b11105b59fd569"
+	// testMessage is signed by the NG key as testSignature.
+	testMessage   = "WiiSOAP known answer"
+	testSignature = "00814c3a96a15d855a5271e4142903021cc6c9010a9ee8d7d80f399775a9" +
+		"001f0b984f43330a02ce2d3cd7f38fe9bba74f548d0377d18489dc0d7759"
+)
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// encodeECCValue returns v as a big-endian field element or signature component.
+func encodeECCValue(v *big.Int) []byte {
+	value := v.Bytes()
+	encoded := make([]byte, eccSize)
+	copy(encoded[eccSize-len(value):], value)
+	return encoded
+}
+
+// encodeECCPoint returns p in the form stored within certificates, its x and y coordinates.
+func encodeECCPoint(p *eccPoint) []byte {
+	return append(encodeECCValue(p.x), encodeECCValue(p.y)...)
+}
+
+func TestECCGenerator(t *testing.T) {
+	if !eccG.onCurve() {
+		t.Fatal("generator is not on the curve")
+	}
+	if eccMultiply(eccG, eccN) != nil {
+		t.Error("multiplying the generator by its order did not give the point at infinity")
+	}
+}
+
+func TestECCPublicKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		privateKey string
+		publicKey  string
+	}{
+		{"MS", testMSPrivateKey, testMSPublicKey},
+		{"NG", testNGPrivateKey, testNGPublicKey},
+	}
+	for _, test := range tests {
+		d := new(big.Int).SetBytes(mustDecodeHex(t, test.privateKey))
+		got := hex.EncodeToString(encodeECCPoint(eccMultiply(eccG, d)))
+		if got != test.publicKey {
+			t.Errorf("%s public key is %s, want %s", test.name, got, test.publicKey)
+		}
+	}
+}
+
+func TestVerifyECDSA(t *testing.T) {
+	publicKey := mustDecodeHex(t, testNGPublicKey)
+	signature := mustDecodeHex(t, testSignature)
+	hash := sha1.Sum([]byte(testMessage))
+
+	if !verifyECDSA(publicKey, hash[:], signature) {
+		t.Fatal("known answer signature was rejected")
+	}
+
+	otherHash := sha1.Sum([]byte(testMessage + "!"))
+	if verifyECDSA(publicKey, otherHash[:], signature) {
+		t.Error("signature was accepted for another message")
+	}
+	if verifyECDSA(mustDecodeHex(t, testMSPublicKey), hash[:], signature) {
+		t.Error("signature was accepted for another key")
+	}
+}
+
+func TestVerifyECDSARejects(t *testing.T) {
+	hash := sha1.Sum([]byte(testMessage))
+	n := encodeECCValue(eccN)
+
+	tests := []struct {
+		name      string
+		publicKey func(key []byte)
+		signature func(signature []byte)
+	}{
+		{
+			name:      "flipped signature bit",
+			signature: func(signature []byte) { signature[eccSize+eccSize/2] ^= 0x10 },
+		},
+		{
+			name:      "point not on the curve",
+			publicKey: func(key []byte) { key[len(key)-1] ^= 0x01 },
+		},
+		{
+			name:      "coordinate outside the field",
+			publicKey: func(key []byte) { key[0] = 0xFF },
+		},
+		{
+			name:      "r equal to n",
+			signature: func(signature []byte) { copy(signature[:eccSize], n) },
+		},
+		{
+			name:      "s equal to n",
+			signature: func(signature []byte) { copy(signature[eccSize:], n) },
+		},
+		{
+			name:      "r greater than n",
+			signature: func(signature []byte) { copy(signature[:eccSize], n); signature[eccSize-1]++ },
+		},
+		{
+			name: "r of zero",
+			signature: func(signature []byte) {
+				for i := 0; i < eccSize; i++ {
+					signature[i] = 0
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		publicKey := mustDecodeHex(t, testNGPublicKey)
+		signature := mustDecodeHex(t, testSignature)
+		if test.publicKey != nil {
+			test.publicKey(publicKey)
+		}
+		if test.signature != nil {
+			test.signature(signature)
+		}
+
+		if verifyECDSA(publicKey, hash[:], signature) {
+			t.Errorf("signature with %s was accepted", test.name)
+		}
+	}
+
+	if verifyECDSA(mustDecodeHex(t, testNGPublicKey), hash[:], mustDecodeHex(t, testSignature)[:eccSize]) {
+		t.Error("truncated signature was accepted")
+	}
+}
@@ -18,66 +18,525 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"github.com/antchfx/xmlquery"
+	"log"
 )
 
-func ecsHandler(e Envelope, doc *xmlquery.Node) (bool, string) {
-	// All actions below are for ECS-related functions.
-	switch e.Action() {
-	// TODO: Make the case functions cleaner. (e.g. Should the response be a variable?)
-	// TODO: Update the responses so that they query the SQL Database for the proper information (e.g. Device Code, Token, etc).
+func init() {
+	// All ECS-related functions must come from a registered account.
+	registerService("ecs",
+		Action{Name: "CheckDeviceStatus", Request: CheckDeviceStatusRequest{}, Auth: AuthUnexpired, Handler: ecsCheckDeviceStatus},
+		Action{Name: "CheckAccountBalance", Request: CheckAccountBalanceRequest{}, Auth: AuthUnexpired, Handler: ecsCheckAccountBalance},
+		Action{Name: "NotifyETicketsSynced", Request: NotifyETicketsSyncedRequest{}, Auth: AuthUnexpired, Handler: ecsNotifyETicketsSynced},
+		Action{Name: "ListETickets", Request: ListETicketsRequest{}, Auth: AuthUnexpired, Handler: ecsListETickets},
+		Action{Name: "GetETickets", Request: GetETicketsRequest{}, Auth: AuthUnexpired, Handler: ecsGetETickets},
+		Action{Name: "PurchaseTitle", Request: PurchaseTitleRequest{}, Auth: AuthUnexpired, Handler: ecsPurchaseTitle},
+		Action{Name: "PurchasePoints", Request: PurchasePointsRequest{}, Auth: AuthUnexpired, Handler: ecsPurchasePoints},
+		Action{Name: "CheckECardBalance", Request: CheckECardBalanceRequest{}, Auth: AuthUnexpired, Handler: ecsCheckECardBalance},
+		Action{Name: "RedeemECard", Request: RedeemECardRequest{}, Auth: AuthUnexpired, Handler: ecsRedeemECard},
+		Action{Name: "ListPurchaseHistory", Request: ListPurchaseHistoryRequest{}, Auth: AuthUnexpired, Handler: ecsListPurchaseHistory},
+		Action{Name: "ListGifts", Request: ListGiftsRequest{}, Auth: AuthUnexpired, Handler: ecsListGifts},
+		Action{Name: "AcceptGift", Request: AcceptGiftRequest{}, Auth: AuthUnexpired, Handler: ecsAcceptGift},
+		Action{Name: "DeclineGift", Request: DeclineGiftRequest{}, Auth: AuthUnexpired, Handler: ecsDeclineGift},
+	)
+}
+
+func ecsCheckDeviceStatus(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	//You need to POST some SOAP from WSC if you wanna get some, honey. ;3
+
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(CheckDeviceStatusResponse{
+		Balance: Balance{
+			Amount:   balance,
+			Currency: "POINTS",
+		},
+		ForceSyncTime: 0,
+		ExtTicketTime: e.Timestamp(),
+		SyncTime:      e.Timestamp(),
+	})
+}
+
+func ecsCheckAccountBalance(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	// This is the same balance PurchaseTitle debits from, so the two always agree.
+	// Points are captured as soon as they are authorized, so no amount is ever held against it.
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(CheckAccountBalanceResponse{
+		Balance: Balance{
+			Amount:   balance,
+			Currency: "POINTS",
+		},
+		MaxBalance: pointsCap,
+	})
+}
+
+func ecsNotifyETicketsSynced(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	// This is a disgusting request, but 20 dollars is 20 dollars. ;3
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(NotifyETicketsSyncedResponse{})
+}
+
+func ecsListETickets(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	// that's all you've got for me? ;3
+
+	owned, err := store.ListOwnedTitles(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	response := ListETicketsResponse{
+		ForceSyncTime: 0,
+		ExtTicketTime: e.Timestamp(),
+		SyncTime:      e.Timestamp(),
+	}
+	for _, title := range owned {
+		response.Tickets = append(response.Tickets, title.Tickets())
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func ecsGetETickets(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*GetETicketsRequest)
+
+	consoleId, err := consoleIdFromDeviceId(account.DeviceId)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidDeviceToken, err)
+	}
+	owned, err := store.ListOwnedTitles(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	// Only tickets belonging to this account may be returned.
+	var tickets []string
+	for _, ticketId := range request.TicketIds {
+		var title *OwnedTitle
+		for i := range owned {
+			if owned[i].TicketId == ticketId {
+				title = &owned[i]
+				break
+			}
+		}
+		if title == nil {
+			return e.ReturnError(ErrorTitleNotOwned, fmt.Errorf("unknown ticket ID %d", ticketId))
+		}
+
+		ticket, err := title.Ticket(consoleId)
+		if err != nil {
+			return e.ReturnError(ErrorTitleUnavailable, err)
+		}
+		contents, err := ticket.Bytes()
+		if err != nil {
+			return e.ReturnError(ErrorTitleUnavailable, err)
+		}
+		tickets = append(tickets, base64.StdEncoding.EncodeToString(contents))
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(GetETicketsResponse{
+		ForceSyncTime: 0,
+		ETickets:      tickets,
+		Certs:         encodedCertChain(),
+	})
+}
+
+func ecsPurchaseTitle(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	// If you wanna fun time, it's gonna cost ya extra sweetie... ;3
+	request := decoded.(*PurchaseTitleRequest)
+
+	titleId, err := parseTitleId(request.TitleId)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidRequest, err)
+	}
+
+	// Gifts are paid for now, but only issued as tickets once accepted by their recipient.
+	if request.RecipientDeviceCode != "" {
+		return purchaseGift(e, request, account, titleId)
+	}
+
+	title, shopErr, err := findPurchasableTitle(account, titleId, request.Price.Amount)
+	if err != nil {
+		return e.ReturnError(shopErr, err)
+	}
+
+	// Tickets are personalised to the console the account was registered with.
+	consoleId, err := consoleIdFromDeviceId(account.DeviceId)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidDeviceToken, err)
+	}
+	ticketId, err := generateTicketId()
+	if err != nil {
+		return e.ReturnError(ErrorTitleUnavailable, err)
+	}
+	owned := OwnedTitle{
+		TicketId: ticketId,
+		TitleId:  titleId,
+		Version:  title.Version,
+	}
+	ticket, err := owned.Ticket(consoleId)
+	if err != nil {
+		return e.ReturnError(ErrorTitleUnavailable, err)
+	}
+	contents, err := ticket.Bytes()
+	if err != nil {
+		return e.ReturnError(ErrorTitleUnavailable, err)
+	}
+
+	// Only debit the account once we know the ticket can be issued.
+	entry, err := newLedgerEntry(account.AccountId, TransactionPurchaseGame, -title.Price, request.TitleId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+	owned.PurchaseDate = entry.Date
+	owned.TransactionId = entry.TransactionId
+
+	// The debit and the title are recorded together, so neither can happen without the other.
+	err = store.PurchaseTitle(owned, *entry)
+	if err == errInsufficientBalance {
+		return e.ReturnError(ErrorInsufficientBalance, err)
+	} else if err == errTitleAlreadyOwned {
+		return e.ReturnError(ErrorTitleAlreadyOwned, err)
+	} else if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(PurchaseTitleResponse{
+		Balance: Balance{
+			Amount:   balance,
+			Currency: "POINTS",
+		},
+		Transactions: entry.Transaction(),
+		SyncTime:     e.Timestamp(),
+		Certs:        encodedCertChain(),
+		TitleId:      request.TitleId,
+		ETickets:     base64.StdEncoding.EncodeToString(contents),
+	})
+}
+
+// purchaseGift debits an account for a title sent to another console, as requested within PurchaseTitle.
+func purchaseGift(e *Envelope, request *PurchaseTitleRequest, account *Account, titleId uint64) (bool, string) {
+	recipient, err := findGiftRecipient(account, request.RecipientDeviceCode)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+
+	// The title must suit the recipient, rather than the sender.
+	title, shopErr, err := findPurchasableTitle(recipient, titleId, request.Price.Amount)
+	if err != nil {
+		return e.ReturnError(shopErr, err)
+	}
+
+	_, entry, err := sendGift(account, recipient, title, request.GiftMessage)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(PurchaseTitleResponse{
+		Balance: Balance{
+			Amount:   balance,
+			Currency: "POINTS",
+		},
+		Transactions: entry.Transaction(),
+		SyncTime:     e.Timestamp(),
+		TitleId:      request.TitleId,
+	})
+}
+
+// findPurchasableTitle returns the catalog title which may be issued to owner at the given price,
+// alongside the error to report should it not be.
+func findPurchasableTitle(owner *Account, titleId uint64, amount int) (*CatalogTitle, *ShopError, error) {
+	// Titles already owned should be downloaded again via GetETickets, not purchased twice.
+	existing, err := store.GetOwnedTitle(owner.AccountId, titleId)
+	if err != nil {
+		return nil, ErrorServiceUnavailable, err
+	}
+	if existing != nil {
+		return nil, ErrorTitleAlreadyOwned, errTitleAlreadyOwned
+	}
+
+	// Only titles within the catalog for the owner's region may be purchased.
+	title, err := store.GetCatalogTitle(titleId)
+	if err != nil {
+		return nil, ErrorServiceUnavailable, err
+	}
+	if title == nil {
+		return nil, ErrorTitleUnavailable, errors.New("title is not within the catalog")
+	}
+	if !title.AvailableIn(owner.Region, owner.Country) {
+		return nil, ErrorRegionMismatch, errors.New("title is not sold within the account's region")
+	}
+
+	// The Wii repeats the price it was shown, which must match what the catalog charges.
+	if amount != title.Price {
+		return nil, ErrorPriceMismatch, errors.New("price does not match catalog")
+	}
+
+	return title, nil, nil
+}
+
+func ecsPurchasePoints(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*PurchasePointsRequest)
+
+	if request.PointsToPurchase <= 0 {
+		return e.ReturnError(ErrorInvalidRequest, errors.New("points to purchase must be positive"))
+	}
+	if paymentProvider == nil {
+		return e.ReturnError(ErrorPaymentFailed, errPaymentsDisabled)
+	}
+
+	// We decide what points cost. The console only repeats the price it was shown, which must match.
+	price := findPointsPrice(request.ItemId, request.PointsToPurchase, request.Price.Currency)
+	if price == nil {
+		return e.ReturnError(ErrorInvalidRequest, errUnknownPrice)
+	}
+	if price.Points != request.PointsToPurchase || price.Amount != request.Price.Amount || price.Currency != request.Price.Currency {
+		return e.ReturnError(ErrorPriceMismatch, errPriceMismatch)
+	}
+
+	// Check the cap before taking any money. It is enforced again when crediting, should purchases race.
+	current, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+	if exceedsPointsCap(current, request.PointsToPurchase) {
+		return e.ReturnError(ErrorPointsCapExceeded, errPointsCapExceeded)
+	}
 
-	case "CheckDeviceStatus":
-		//You need to POST some SOAP from WSC if you wanna get some, honey. ;3
+	// Money is taken before crediting the account, so a failed capture never grants points.
+	authorizationId, err := paymentProvider.Authorize(Payment{
+		AccountId: account.AccountId,
+		Points:    price.Points,
+		Amount:    price.Amount,
+		Currency:  price.Currency,
+		Method:    request.Payment.PaymentMethod,
+	})
+	if err != nil {
+		return e.ReturnError(ErrorPaymentFailed, err)
+	}
+	err = paymentProvider.Capture(authorizationId)
+	if err != nil {
+		// Nothing was taken, but the authorization would otherwise hold the money until it lapses.
+		voidErr := paymentProvider.Void(authorizationId)
+		if voidErr != nil {
+			log.Printf("failed to void payment %s: %v\n", authorizationId, voidErr)
+		}
+		return e.ReturnError(ErrorPaymentFailed, err)
+	}
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddCustomType(Balance{
-			Amount:   2018,
+	entry, err := recordTransaction(account.AccountId, TransactionPurchasePoints, price.Points, "")
+	if err != nil {
+		// The points were never credited, so give back what was paid.
+		refundErr := paymentProvider.Refund(authorizationId)
+		if refundErr != nil {
+			log.Printf("failed to refund payment %s: %v\n", authorizationId, refundErr)
+		}
+		if err == errPointsCapExceeded {
+			return e.ReturnError(ErrorPointsCapExceeded, err)
+		}
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(PurchasePointsResponse{
+		Balance: Balance{
+			Amount:   balance,
 			Currency: "POINTS",
-		})
-		e.AddKVNode("ForceSyncTime", "0")
-		e.AddKVNode("ExtTicketTime", e.Timestamp())
-		e.AddKVNode("SyncTime", e.Timestamp())
-		break
+		},
+		Transactions: entry.Transaction(),
+	})
+}
 
-	case "NotifyETicketsSynced":
-		// This is a disgusting request, but 20 dollars is 20 dollars. ;3
+func ecsCheckECardBalance(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*CheckECardBalanceRequest)
 
-		fmt.Println("The request is valid! Responding...")
-		break
+	card, err := findECard(request.ECardId)
+	if err != nil {
+		return e.ReturnError(eCardError(err), err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(CheckECardBalanceResponse{
+		Balance: Balance{
+			Amount:   card.Value,
+			Currency: card.Currency,
+		},
+	})
+}
 
-	case "ListETickets":
-		// that's all you've got for me? ;3
+func ecsRedeemECard(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*RedeemECardRequest)
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddKVNode("ForceSyncTime", "0")
-		e.AddKVNode("ExtTicketTime", e.Timestamp())
-		e.AddKVNode("SyncTime", e.Timestamp())
-		break
+	card, err := findECard(request.ECardId)
+	if err != nil {
+		return e.ReturnError(eCardError(err), err)
+	}
+	entry, err := redeemECard(account.AccountId, card)
+	if err != nil {
+		return e.ReturnError(eCardError(err), err)
+	}
 
-	case "PurchaseTitle":
-		// If you wanna fun time, it's gonna cost ya extra sweetie... ;3
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddCustomType(Balance{
-			Amount:   2018,
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(RedeemECardResponse{
+		Balance: Balance{
+			Amount:   balance,
 			Currency: "POINTS",
-		})
-		e.AddCustomType(Transactions{
-			TransactionId: "00000000",
-			Date:          e.Timestamp(),
-			Type:          "PURCHGAME",
-		})
-		e.AddKVNode("SyncTime", e.Timestamp())
-		e.AddKVNode("Certs", "00000000")
-		e.AddKVNode("TitleId", "00000000")
-		e.AddKVNode("ETickets", "00000000")
-		break
+		},
+		Transactions: entry.Transaction(),
+	})
+}
 
+// eCardError returns the error reported to consoles for a failure to find or redeem a card.
+func eCardError(err error) *ShopError {
+	switch err {
+	case errInvalidECardCode:
+		return ErrorInvalidECard
+	case errECardRedeemed:
+		return ErrorECardRedeemed
+	case errECardExpired:
+		return ErrorECardExpired
+	case errPointsCapExceeded:
+		return ErrorPointsCapExceeded
 	default:
-		return false, "WiiSOAP can't handle this. Try again later or actually use a Wii instead of a computer."
+		return ErrorServiceUnavailable
 	}
+}
 
-	return e.ReturnSuccess()
+func ecsListPurchaseHistory(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*ListPurchaseHistoryRequest)
+
+	entries, err := store.ListTransactions(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	response := ListPurchaseHistoryResponse{
+		ListResultTotalSize: len(entries),
+	}
+	start, end := request.page(len(entries))
+	for _, entry := range entries[start:end] {
+		response.Transactions = append(response.Transactions, entry.PurchaseHistory())
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func ecsListGifts(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	gifts, err := store.ListPendingGifts(account.AccountId)
+	if err != nil {
+		return e.ReturnError(ErrorServiceUnavailable, err)
+	}
+
+	response := ListGiftsResponse{}
+	for _, gift := range gifts {
+		response.Gifts = append(response.Gifts, gift.GiftInfo())
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func ecsAcceptGift(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*AcceptGiftRequest)
+
+	gift, err := findPendingGift(account, request.GiftId)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+
+	// Tickets are personalised to the console the recipient registered with.
+	consoleId, err := consoleIdFromDeviceId(account.DeviceId)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidDeviceToken, err)
+	}
+	owned, err := acceptGift(gift)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+
+	// Should the ticket fail to be issued here, it can still be fetched via GetETickets.
+	ticket, err := owned.Ticket(consoleId)
+	if err != nil {
+		return e.ReturnError(ErrorTitleUnavailable, err)
+	}
+	contents, err := ticket.Bytes()
+	if err != nil {
+		return e.ReturnError(ErrorTitleUnavailable, err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(AcceptGiftResponse{
+		SyncTime: e.Timestamp(),
+		Certs:    encodedCertChain(),
+		TitleId:  fmt.Sprintf("%016x", owned.TitleId),
+		ETickets: base64.StdEncoding.EncodeToString(contents),
+	})
+}
+
+func ecsDeclineGift(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*DeclineGiftRequest)
+
+	gift, err := findPendingGift(account, request.GiftId)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+	err = declineGift(gift)
+	if err != nil {
+		return e.ReturnError(giftError(err), err)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(DeclineGiftResponse{})
+}
+
+// giftError returns the error reported to consoles for a failure to send, accept or decline a gift.
+func giftError(err error) *ShopError {
+	switch err {
+	case errInvalidDeviceCode, errUnknownRecipient, errGiftToSelf:
+		return ErrorInvalidRecipient
+	case errGiftAlreadySent, errTitleAlreadyOwned:
+		return ErrorTitleAlreadyOwned
+	case errGiftNotFound, errGiftResolved:
+		return ErrorInvalidRequest
+	case errInsufficientBalance:
+		return ErrorInsufficientBalance
+	default:
+		return ErrorServiceUnavailable
+	}
 }
@@ -0,0 +1,321 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"strings"
+)
+
+// defaultLanguage is used for reasons which have not been translated into the console's language.
+const defaultLanguage = "en"
+
+// ShopError is an error reported to consoles via ErrorCode, alongside a reason which may be shown to the user.
+type ShopError struct {
+	Code int
+	// Reasons maps a language, as sent by the console such as "en" or "ja", to the reason shown within it.
+	Reasons map[string]string
+}
+
+// Reason returns the reason for this error within the given language, falling back to English.
+func (s *ShopError) Reason(language string) string {
+	if reason, ok := s.Reasons[strings.ToLower(language)]; ok {
+		return reason
+	}
+	return s.Reasons[defaultLanguage]
+}
+
+// Every error WiiSOAP reports. Codes are those displayed by the Wii Shop Channel,
+// with the exception of ErrorServiceUnavailable, ErrorPaymentFailed, ErrorInvalidRecipient, ErrorPointsCapExceeded,
+// the ECard errors and the authentication errors other than ErrorInvalidDeviceToken, which are specific to WiiSOAP.
+var (
+	// ErrorInvalidRequest is returned when a request omits a mandatory key, or contains a malformed value.
+	ErrorInvalidRequest = &ShopError{
+		Code: 5,
+		Reasons: map[string]string{
+			"en": "The request was invalid.",
+			"ja": "リクエストが無効です。",
+			"de": "Die Anfrage ist ungültig.",
+			"fr": "La requête est invalide.",
+			"es": "La solicitud no es válida.",
+			"it": "La richiesta non è valida.",
+			"nl": "Het verzoek is ongeldig.",
+		},
+	}
+	// ErrorServiceUnavailable is returned when WiiSOAP itself failed, such as when the database cannot be reached.
+	ErrorServiceUnavailable = &ShopError{
+		Code: 9,
+		Reasons: map[string]string{
+			"en": "The Wii Shop Channel is currently unavailable. Please try again later.",
+			"ja": "現在、Wiiショッピングチャンネルはご利用いただけません。しばらくしてからもう一度お試しください。",
+			"de": "Der Wii-Shop-Kanal ist derzeit nicht verfügbar. Bitte versuche es später erneut.",
+			"fr": "La Chaîne Boutique Wii est actuellement indisponible. Veuillez réessayer plus tard.",
+			"es": "El Canal Tienda Wii no está disponible en este momento. Inténtalo de nuevo más tarde.",
+			"it": "Il Canale Wii Shop non è al momento disponibile. Riprova più tardi.",
+			"nl": "Het Wii-winkelkanaal is momenteel niet beschikbaar. Probeer het later opnieuw.",
+		},
+	}
+	// ErrorPaymentFailed is returned when points could not be paid for, such as when a payment is declined.
+	ErrorPaymentFailed = &ShopError{
+		Code: 10,
+		Reasons: map[string]string{
+			"en": "Your payment could not be completed.",
+			"ja": "お支払いを完了できませんでした。",
+			"de": "Deine Zahlung konnte nicht abgeschlossen werden.",
+			"fr": "Votre paiement n'a pas pu être effectué.",
+			"es": "No se ha podido completar el pago.",
+			"it": "Non è stato possibile completare il pagamento.",
+			"nl": "Je betaling kon niet worden voltooid.",
+		},
+	}
+	// ErrorInvalidECard is returned when a Wii Points Card code is mistyped or unknown.
+	ErrorInvalidECard = &ShopError{
+		Code: 11,
+		Reasons: map[string]string{
+			"en": "This Wii Points Card number is not valid.",
+			"ja": "このWiiポイントプリペイドカードの番号は無効です。",
+			"de": "Diese Wii-Punkte-Karten-Nummer ist ungültig.",
+			"fr": "Ce numéro de Carte Points Wii n'est pas valide.",
+			"es": "Este número de Tarjeta Wii Points no es válido.",
+			"it": "Questo numero di Wii Points Card non è valido.",
+			"nl": "Dit Wii Points Card-nummer is ongeldig.",
+		},
+	}
+	// ErrorECardRedeemed is returned when a Wii Points Card has already been redeemed.
+	ErrorECardRedeemed = &ShopError{
+		Code: 12,
+		Reasons: map[string]string{
+			"en": "This Wii Points Card has already been used.",
+			"ja": "このWiiポイントプリペイドカードはすでに使用されています。",
+			"de": "Diese Wii-Punkte-Karte wurde bereits verwendet.",
+			"fr": "Cette Carte Points Wii a déjà été utilisée.",
+			"es": "Esta Tarjeta Wii Points ya se ha utilizado.",
+			"it": "Questa Wii Points Card è già stata utilizzata.",
+			"nl": "Deze Wii Points Card is al gebruikt.",
+		},
+	}
+	// ErrorECardExpired is returned when a Wii Points Card can no longer be redeemed.
+	ErrorECardExpired = &ShopError{
+		Code: 13,
+		Reasons: map[string]string{
+			"en": "This Wii Points Card has expired.",
+			"ja": "このWiiポイントプリペイドカードは有効期限が切れています。",
+			"de": "Diese Wii-Punkte-Karte ist abgelaufen.",
+			"fr": "Cette Carte Points Wii a expiré.",
+			"es": "Esta Tarjeta Wii Points ha caducado.",
+			"it": "Questa Wii Points Card è scaduta.",
+			"nl": "Deze Wii Points Card is verlopen.",
+		},
+	}
+	// ErrorInvalidRecipient is returned when a gift cannot be sent to the given Wii Number.
+	ErrorInvalidRecipient = &ShopError{
+		Code: 14,
+		Reasons: map[string]string{
+			"en": "Gifts cannot be sent to this Wii Number.",
+			"ja": "このWii番号にはプレゼントを贈ることができません。",
+			"de": "An diese Wii-Nummer können keine Geschenke gesendet werden.",
+			"fr": "Impossible d'envoyer un cadeau à ce numéro de Wii.",
+			"es": "No se pueden enviar regalos a este número de Wii.",
+			"it": "Non è possibile inviare regali a questo numero Wii.",
+			"nl": "Er kunnen geen cadeaus naar dit Wii-nummer worden verzonden.",
+		},
+	}
+	// ErrorPointsCapExceeded is returned when adding points would take an account beyond the points cap.
+	ErrorPointsCapExceeded = &ShopError{
+		Code: 15,
+		Reasons: map[string]string{
+			"en": "You cannot hold any more Wii Points.",
+			"ja": "これ以上Wiiポイントを保有することはできません。",
+			"de": "Du kannst keine weiteren Wii-Punkte besitzen.",
+			"fr": "Vous ne pouvez pas posséder plus de Points Wii.",
+			"es": "No puedes tener más Wii Points.",
+			"it": "Non puoi possedere altri Wii Points.",
+			"nl": "Je kunt niet meer Wii Points bezitten.",
+		},
+	}
+	// ErrorDeviceMismatch is returned when a device token is used by a console other than the one it was issued to.
+	ErrorDeviceMismatch = &ShopError{
+		Code: 16,
+		Reasons: map[string]string{
+			"en": "This account is registered to another Wii.",
+			"ja": "このアカウントは別のWiiに登録されています。",
+			"de": "Dieses Konto ist auf einer anderen Wii registriert.",
+			"fr": "Ce compte est enregistré sur une autre console Wii.",
+			"es": "Esta cuenta está registrada en otra consola Wii.",
+			"it": "Questo account è registrato su un'altra console Wii.",
+			"nl": "Dit account is geregistreerd op een andere Wii.",
+		},
+	}
+	// ErrorAccountInactive is returned when a request's account is no longer registered, such as after being banned.
+	ErrorAccountInactive = &ShopError{
+		Code: 17,
+		Reasons: map[string]string{
+			"en": "This Wii is no longer registered.",
+			"ja": "このWiiは登録されていません。",
+			"de": "Diese Wii ist nicht mehr registriert.",
+			"fr": "Cette console Wii n'est plus enregistrée.",
+			"es": "Esta consola Wii ya no está registrada.",
+			"it": "Questa console Wii non è più registrata.",
+			"nl": "Deze Wii is niet langer geregistreerd.",
+		},
+	}
+	// ErrorDeviceTokenExpired is returned when a request's device token has expired,
+	// and must be renewed via SyncRegistration before it can be used again.
+	ErrorDeviceTokenExpired = &ShopError{
+		Code: 18,
+		Reasons: map[string]string{
+			"en": "Your Wii must be authenticated again.",
+			"ja": "このWiiを再度認証する必要があります。",
+			"de": "Deine Wii muss erneut authentifiziert werden.",
+			"fr": "Votre console Wii doit être authentifiée à nouveau.",
+			"es": "Tu consola Wii debe autenticarse de nuevo.",
+			"it": "La tua console Wii deve essere autenticata di nuovo.",
+			"nl": "Je Wii moet opnieuw worden geverifieerd.",
+		},
+	}
+	// ErrorRegistrationFailed is returned when a console could not be registered or its registration changed,
+	// and when its serial number does not match the one it registered with.
+	ErrorRegistrationFailed = &ShopError{
+		Code: 7,
+		Reasons: map[string]string{
+			"en": "Your Wii could not be registered.",
+			"ja": "このWiiを登録できませんでした。",
+			"de": "Deine Wii konnte nicht registriert werden.",
+			"fr": "Votre console Wii n'a pas pu être enregistrée.",
+			"es": "No se ha podido registrar tu consola Wii.",
+			"it": "Non è stato possibile registrare la tua console Wii.",
+			"nl": "Je Wii kon niet worden geregistreerd.",
+		},
+	}
+	// ErrorInvalidDeviceToken is returned when a request's device token does not match any registered account.
+	ErrorInvalidDeviceToken = &ShopError{
+		Code: 903,
+		Reasons: map[string]string{
+			"en": "Your Wii could not be authenticated.",
+			"ja": "このWiiを認証できませんでした。",
+			"de": "Deine Wii konnte nicht authentifiziert werden.",
+			"fr": "Votre console Wii n'a pas pu être authentifiée.",
+			"es": "No se ha podido autenticar tu consola Wii.",
+			"it": "Non è stato possibile autenticare la tua console Wii.",
+			"nl": "Je Wii kon niet worden geverifieerd.",
+		},
+	}
+	// ErrorTitleUnavailable is returned when a ticket cannot be issued for the requested title.
+	ErrorTitleUnavailable = &ShopError{
+		Code: 619,
+		Reasons: map[string]string{
+			"en": "This title is not available.",
+			"ja": "このソフトは現在ご利用いただけません。",
+			"de": "Dieser Titel ist nicht verfügbar.",
+			"fr": "Ce titre n'est pas disponible.",
+			"es": "Este título no está disponible.",
+			"it": "Questo titolo non è disponibile.",
+			"nl": "Deze titel is niet beschikbaar.",
+		},
+	}
+	// ErrorTitleNotOwned is returned when requesting a ticket for a title the account does not own.
+	ErrorTitleNotOwned = &ShopError{
+		Code: 620,
+		Reasons: map[string]string{
+			"en": "You do not own this title.",
+			"ja": "このソフトを所有していません。",
+			"de": "Du besitzt diesen Titel nicht.",
+			"fr": "Vous ne possédez pas ce titre.",
+			"es": "No tienes este título.",
+			"it": "Non possiedi questo titolo.",
+			"nl": "Je bezit deze titel niet.",
+		},
+	}
+	// ErrorTitleAlreadyOwned is returned when purchasing a title the account already owns.
+	ErrorTitleAlreadyOwned = &ShopError{
+		Code: 621,
+		Reasons: map[string]string{
+			"en": "You already own this title.",
+			"ja": "このソフトはすでに所有しています。",
+			"de": "Du besitzt diesen Titel bereits.",
+			"fr": "Vous possédez déjà ce titre.",
+			"es": "Ya tienes este título.",
+			"it": "Possiedi già questo titolo.",
+			"nl": "Je bezit deze titel al.",
+		},
+	}
+	// ErrorRegionMismatch is returned when purchasing a title not sold within the account's region.
+	ErrorRegionMismatch = &ShopError{
+		Code: 622,
+		Reasons: map[string]string{
+			"en": "This title is not available in your region.",
+			"ja": "このソフトはお住まいの地域ではご利用いただけません。",
+			"de": "Dieser Titel ist in deiner Region nicht verfügbar.",
+			"fr": "Ce titre n'est pas disponible dans votre région.",
+			"es": "Este título no está disponible en tu región.",
+			"it": "Questo titolo non è disponibile nella tua regione.",
+			"nl": "Deze titel is niet beschikbaar in jouw regio.",
+		},
+	}
+	// ErrorPriceMismatch is returned when the price a console was shown no longer matches the catalog.
+	ErrorPriceMismatch = &ShopError{
+		Code: 623,
+		Reasons: map[string]string{
+			"en": "The price of this title has changed.",
+			"ja": "このソフトの価格が変更されました。",
+			"de": "Der Preis dieses Titels hat sich geändert.",
+			"fr": "Le prix de ce titre a changé.",
+			"es": "El precio de este título ha cambiado.",
+			"it": "Il prezzo di questo titolo è cambiato.",
+			"nl": "De prijs van deze titel is gewijzigd.",
+		},
+	}
+	// ErrorInsufficientBalance is returned when an account cannot afford a purchase.
+	ErrorInsufficientBalance = &ShopError{
+		Code: 642,
+		Reasons: map[string]string{
+			"en": "You do not have enough Wii Points.",
+			"ja": "Wiiポイントが足りません。",
+			"de": "Du hast nicht genügend Wii-Punkte.",
+			"fr": "Vous n'avez pas assez de Points Wii.",
+			"es": "No tienes suficientes Wii Points.",
+			"it": "Non hai abbastanza Wii Points.",
+			"nl": "Je hebt niet genoeg Wii Points.",
+		},
+	}
+	// ErrorTitleNotFound is returned when a title is not within the catalog.
+	ErrorTitleNotFound = &ShopError{
+		Code: 1001,
+		Reasons: map[string]string{
+			"en": "This title could not be found.",
+			"ja": "このソフトが見つかりませんでした。",
+			"de": "Dieser Titel wurde nicht gefunden.",
+			"fr": "Ce titre est introuvable.",
+			"es": "No se ha encontrado este título.",
+			"it": "Impossibile trovare questo titolo.",
+			"nl": "Deze titel kon niet worden gevonden.",
+		},
+	}
+	// ErrorUnknownSystemTitle is returned when requesting a system title not configured for a region.
+	ErrorUnknownSystemTitle = &ShopError{
+		Code: 1101,
+		Reasons: map[string]string{
+			"en": "This update is not available.",
+			"ja": "この更新データはご利用いただけません。",
+			"de": "Dieses Update ist nicht verfügbar.",
+			"fr": "Cette mise à jour n'est pas disponible.",
+			"es": "Esta actualización no está disponible.",
+			"it": "Questo aggiornamento non è disponibile.",
+			"nl": "Deze update is niet beschikbaar.",
+		},
+	}
+)
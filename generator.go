@@ -0,0 +1,68 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const (
+	// digitCharacters are those numeric identifiers are made up of.
+	digitCharacters = "0123456789"
+	// tokenCharacters are those device tokens are made up of.
+	tokenCharacters = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	// accountIdLength is the length of an account ID, as stored within userbase.
+	accountIdLength = 9
+	// deviceTokenLength is the length of a device token before the Wii hashes it.
+	deviceTokenLength = 21
+)
+
+// randomString returns n characters chosen uniformly from the given characters.
+// Everything generated here may be used to identify or authenticate an account, so crypto/rand is always used.
+func randomString(n int, characters string) (string, error) {
+	max := big.NewInt(int64(len(characters)))
+
+	b := make([]byte, n)
+	for i := range b {
+		// rand.Int is uniform over [0, max), so no character is more likely than another.
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = characters[index.Int64()]
+	}
+	return string(b), nil
+}
+
+// randomDigits returns a string of n random decimal digits, suitable for use as an identifier.
+func randomDigits(n int) (string, error) {
+	return randomString(n, digitCharacters)
+}
+
+// generateAccountId returns a random account ID, zero-padded to 9 digits.
+// Callers must handle collisions with existing accounts.
+func generateAccountId() (string, error) {
+	return randomDigits(accountIdLength)
+}
+
+// generateDeviceToken returns a random device token.
+func generateDeviceToken() (string, error) {
+	return randomString(deviceTokenLength, tokenCharacters)
+}
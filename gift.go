@@ -0,0 +1,194 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// GiftPending is the status of a gift its recipient has yet to accept or decline.
+	GiftPending = "P"
+	// GiftAccepted is the status of a gift which has been issued as a ticket to its recipient.
+	GiftAccepted = "A"
+	// GiftDeclined is the status of a gift its recipient refused, refunding its sender.
+	GiftDeclined = "D"
+)
+
+var (
+	errGiftToSelf        = errors.New("cannot send a gift to yourself")
+	errUnknownRecipient  = errors.New("recipient is not registered")
+	errGiftAlreadySent   = errors.New("recipient already has a pending gift of this title")
+	errGiftNotFound      = errors.New("gift does not exist")
+	errGiftResolved      = errors.New("gift has already been accepted or declined")
+	errTitleAlreadyOwned = errors.New("title is already owned")
+)
+
+// Gift is a title paid for by one account, waiting to be issued to another.
+// Tickets are personalised to a console, so none is issued until the recipient accepts.
+type Gift struct {
+	GiftId           string
+	SenderAccountId  string
+	SenderDeviceCode string
+	// RecipientAccountId is the only account which may accept or decline this gift.
+	RecipientAccountId string
+	TitleId            uint64
+	// Amount is the points paid by the sender, refunded should the gift be declined.
+	Amount        int
+	TransactionId string
+	Message       string
+	Status        string
+	// CreatedAt and ResolvedAt are in milliseconds since the epoch, with ResolvedAt being 0 while pending.
+	CreatedAt  int64
+	ResolvedAt int64
+}
+
+// GiftInfo returns the GiftInfo structure describing this gift.
+func (g *Gift) GiftInfo() GiftInfo {
+	return GiftInfo{
+		GiftId:           g.GiftId,
+		TitleId:          fmt.Sprintf("%016x", g.TitleId),
+		SenderDeviceCode: g.SenderDeviceCode,
+		Message:          g.Message,
+		Date:             g.CreatedAt,
+	}
+}
+
+// findGiftRecipient returns the registered account a gift may be sent to, by the Wii Number typed by the sender.
+func findGiftRecipient(sender *Account, deviceCode string) (*Account, error) {
+	deviceCode = strings.NewReplacer("-", "", " ", "").Replace(deviceCode)
+	err := validateDeviceCode(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := store.GetAccountByDeviceCode(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	// Accounts registered before device codes were checked may claim another console's code.
+	if recipient == nil || checkDeviceCodeOwner(deviceCode, recipient.DeviceId) != nil {
+		return nil, errUnknownRecipient
+	}
+	if recipient.AccountId == sender.AccountId {
+		return nil, errGiftToSelf
+	}
+
+	return recipient, nil
+}
+
+// sendGift debits the sender for a title, leaving it pending for the recipient to accept.
+func sendGift(sender *Account, recipient *Account, title *CatalogTitle, message string) (*Gift, *LedgerEntry, error) {
+	pending, err := store.ListPendingGifts(recipient.AccountId)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, gift := range pending {
+		if gift.TitleId == title.TitleId {
+			return nil, nil, errGiftAlreadySent
+		}
+	}
+
+	giftId, err := randomDigits(10)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry, err := newLedgerEntry(sender.AccountId, TransactionPurchaseGame, -title.Price, fmt.Sprintf("%016x", title.TitleId))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gift := Gift{
+		GiftId:             giftId,
+		SenderAccountId:    sender.AccountId,
+		SenderDeviceCode:   sender.DeviceCode,
+		RecipientAccountId: recipient.AccountId,
+		TitleId:            title.TitleId,
+		Amount:             title.Price,
+		TransactionId:      entry.TransactionId,
+		Message:            message,
+		Status:             GiftPending,
+		CreatedAt:          entry.Date,
+	}
+	err = store.SendGift(gift, *entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &gift, entry, nil
+}
+
+// findPendingGift returns a gift awaiting the given recipient.
+func findPendingGift(recipient *Account, giftId string) (*Gift, error) {
+	gift, err := store.GetGift(giftId)
+	if err != nil {
+		return nil, err
+	}
+	// Gifts for other accounts are treated as unknown, rather than revealing they exist.
+	if gift == nil || gift.RecipientAccountId != recipient.AccountId {
+		return nil, errGiftNotFound
+	}
+	if gift.Status != GiftPending {
+		return nil, errGiftResolved
+	}
+
+	return gift, nil
+}
+
+// acceptGift issues a gift to its recipient, returning the title they now own.
+func acceptGift(gift *Gift) (*OwnedTitle, error) {
+	title, err := store.GetCatalogTitle(gift.TitleId)
+	if err != nil {
+		return nil, err
+	}
+	if title == nil {
+		return nil, errors.New("gifted title is no longer within the catalog")
+	}
+
+	ticketId, err := generateTicketId()
+	if err != nil {
+		return nil, err
+	}
+	owned := OwnedTitle{
+		TicketId:      ticketId,
+		TitleId:       gift.TitleId,
+		Version:       title.Version,
+		PurchaseDate:  time.Now().UnixNano() / int64(time.Millisecond),
+		TransactionId: gift.TransactionId,
+	}
+
+	err = store.AcceptGift(*gift, owned)
+	if err != nil {
+		return nil, err
+	}
+
+	return &owned, nil
+}
+
+// declineGift refuses a gift, refunding its sender.
+func declineGift(gift *Gift) error {
+	refund, err := newLedgerEntry(gift.SenderAccountId, TransactionRefund, gift.Amount, fmt.Sprintf("%016x", gift.TitleId))
+	if err != nil {
+		return err
+	}
+
+	return store.DeclineGift(*gift, *refund)
+}
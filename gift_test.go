@@ -0,0 +1,105 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+	"github.com/RiiConnect24/wiino/golang"
+	"testing"
+)
+
+// setupTestRecipient registers an account for console 0402503b under its Wii Number with the given counter,
+// which changes each time the console is formatted.
+func setupTestRecipient(t *testing.T, counter uint16) *Account {
+	account := &Account{
+		DeviceId:   "4362227771",
+		DeviceCode: fmt.Sprint(wiino.NWC24MakeUserID(0x0402503b, counter, 1, 1)),
+		Region:     "USA",
+		Country:    "US",
+		Language:   "en",
+		Status:     DeviceStatusRegistered,
+	}
+	_, err := createAccount(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestFindGiftRecipient(t *testing.T) {
+	defer setupTestStore(t)()
+	sender := setupTestAccount(t)
+	recipient := setupTestRecipient(t, 1)
+
+	found, err := findGiftRecipient(sender, recipient.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.AccountId != recipient.AccountId {
+		t.Errorf("found account %s, want %s", found.AccountId, recipient.AccountId)
+	}
+
+	// Once formatted, the console registers again with a new Wii Number, and its old one is no longer in use.
+	oldDeviceCode := recipient.DeviceCode
+	recipient.DeviceCode = fmt.Sprint(wiino.NWC24MakeUserID(0x0402503b, 2, 1, 1))
+	err = store.UnregisterAccount(recipient.AccountId, "revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = findGiftRecipient(sender, oldDeviceCode)
+	if err != errUnknownRecipient {
+		t.Errorf("finding an unregistered Wii Number returned %v, want %v", err, errUnknownRecipient)
+	}
+	err = store.ReactivateAccount(*recipient, "reactivated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err = findGiftRecipient(sender, recipient.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.AccountId != recipient.AccountId {
+		t.Errorf("found account %s, want %s", found.AccountId, recipient.AccountId)
+	}
+	_, err = findGiftRecipient(sender, oldDeviceCode)
+	if err != errUnknownRecipient {
+		t.Errorf("finding a replaced Wii Number returned %v, want %v", err, errUnknownRecipient)
+	}
+}
+
+func TestFindGiftRecipientOwner(t *testing.T) {
+	defer setupTestStore(t)()
+	sender := setupTestAccount(t)
+
+	// This account claims a Wii Number generated for another console.
+	impostor := &Account{
+		DeviceId:   "4362227772",
+		DeviceCode: fmt.Sprint(wiino.NWC24MakeUserID(0x0402503b, 1, 1, 1)),
+		Region:     "USA",
+		Status:     DeviceStatusRegistered,
+	}
+	_, err := createAccount(impostor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = findGiftRecipient(sender, impostor.DeviceCode)
+	if err != errUnknownRecipient {
+		t.Errorf("finding another console's Wii Number returned %v, want %v", err, errUnknownRecipient)
+	}
+}
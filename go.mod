@@ -6,4 +6,5 @@ require (
 	github.com/RiiConnect24/wiino v0.0.0-20200719211820-910fed2fa406
 	github.com/antchfx/xmlquery v1.3.11
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.10
 )
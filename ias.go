@@ -18,162 +18,183 @@
 package main
 
 import (
-	"crypto/md5"
-	sha2562 "crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/RiiConnect24/wiino/golang"
-	"github.com/antchfx/xmlquery"
-	"github.com/go-sql-driver/mysql"
-	"log"
-	"math/rand"
-	"strconv"
 )
 
-func iasHandler(e Envelope, doc *xmlquery.Node) (bool, string) {
-	// All IAS-related functions should contain these keys.
-	region, err := getKey(doc, "Region")
-	if err != nil {
-		return e.ReturnError(5, "not good enough for me. ;3", err)
-	}
-	country, err := getKey(doc, "Country")
+func init() {
+	// All IAS-related requests embed IASRequest.
+	registerService("ias",
+		Action{Name: "CheckRegistration", Request: CheckRegistrationRequest{}, Handler: iasCheckRegistration},
+		Action{Name: "GetChallenge", Request: GetChallengeRequest{}, Handler: iasGetChallenge},
+		Action{Name: "GetRegistrationInfo", Request: GetRegistrationInfoRequest{}, Auth: AuthAccount, Handler: iasGetRegistrationInfo},
+		Action{Name: "Register", Request: RegisterRequest{}, Handler: iasRegister},
+		// Consoles renew their device token here, so unlike ECS we accept expired tokens within their renewal period.
+		Action{Name: "SyncRegistration", Request: SyncRegistrationRequest{}, Auth: AuthAccount, Handler: iasSyncRegistration},
+		Action{Name: "Unregister", Request: UnregisterRequest{}, Auth: AuthAccount, Handler: iasUnregister},
+	)
+}
+
+func iasCheckRegistration(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	serialNo := decoded.(*CheckRegistrationRequest).SerialNumber
+
+	// The Shop only calls Register for consoles we report as unknown.
+	status := DeviceStatusUnknown
+	registered, err := store.GetAccountByDevice(e.DeviceId())
 	if err != nil {
-		return e.ReturnError(5, "not good enough for me. ;3", err)
+		return e.ReturnError(ErrorServiceUnavailable, err)
 	}
-	language, err := getKey(doc, "Language")
-	if err != nil {
-		return e.ReturnError(5, "not good enough for me. ;3", err)
+	if registered != nil {
+		// Should this device ID now be used by another console, we refuse rather than reveal the registered serial number.
+		if registered.SerialNo != serialNo {
+			return e.ReturnError(ErrorRegistrationFailed, errors.New("serial number does not match the registered console"))
+		}
+		status = registered.RegistrationStatus()
 	}
 
-	// All actions below are for IAS-related functions.
-	switch e.Action() {
-	// TODO: Make the case functions cleaner. (e.g. Should the response be a variable?)
-	// TODO: Update the responses so that they query the SQL Database for the proper information (e.g. Device Code, Token, etc).
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(CheckRegistrationResponse{
+		OriginalSerialNumber: serialNo,
+		DeviceStatus:         status,
+	})
+}
 
-	case "CheckRegistration":
-		serialNo, err := getKey(doc, "SerialNumber")
-		if err != nil {
-			return e.ReturnError(5, "not good enough for me. ;3", err)
-		}
+func iasGetChallenge(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	fmt.Println("The request is valid! Responding...")
+	// The official Wii Shop Channel requests a Challenge from the server, and promptly disregards it.
+	// (Sometimes, it may not request a challenge at all.) No attempt is made to validate the response.
+	// It then uses another hard-coded value in place of this returned value entirely in any situation.
+	// For this reason, we consider it irrelevant.
+	return e.ReturnSuccess(GetChallengeResponse{
+		Challenge: SharedChallenge,
+	})
+}
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddKVNode("OriginalSerialNumber", serialNo)
-		e.AddKVNode("DeviceStatus", "R")
-		break
-
-	case "GetChallenge":
-		fmt.Println("The request is valid! Responding...")
-		// The official Wii Shop Channel requests a Challenge from the server, and promptly disregards it.
-		// (Sometimes, it may not request a challenge at all.) No attempt is made to validate the response.
-		// It then uses another hard-coded value in place of this returned value entirely in any situation.
-		// For this reason, we consider it irrelevant.
-		e.AddKVNode("Challenge", SharedChallenge)
-		break
-
-	case "GetRegistrationInfo":
-		reason := "how dirty. ;3"
-		accountId, err := getKey(doc, "AccountId")
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
+func iasGetRegistrationInfo(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	// We only store a hash of the device token, so the only token we can send is a new one.
+	deviceToken, err := renewDeviceToken(account)
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
 
-		deviceCode, err := getKey(doc, "DeviceCode")
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(GetRegistrationInfoResponse{
+		AccountId:          account.AccountId,
+		DeviceToken:        deviceToken,
+		DeviceTokenExpired: account.DeviceTokenExpired(),
+		Country:            account.Country,
+		ExtAccountId:       account.ExtAccountId,
+		DeviceCode:         account.DeviceCode,
+		DeviceStatus:       account.Status,
+		Currency:           "POINTS",
+	})
+}
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddKVNode("AccountId", accountId)
-		e.AddKVNode("DeviceToken", "00000000")
-		e.AddKVNode("DeviceTokenExpired", "false")
-		e.AddKVNode("Country", country)
-		e.AddKVNode("ExtAccountId", "")
-		e.AddKVNode("DeviceCode", deviceCode)
-		e.AddKVNode("DeviceStatus", "R")
-		// This _must_ be POINTS.
-		e.AddKVNode("Currency", "POINTS")
-		break
-
-	case "Register":
-		reason := "disgustingly invalid. ;3"
-		deviceCode, err := getKey(doc, "DeviceCode")
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
+func iasRegister(e *Envelope, decoded interface{}, _ *Account) (bool, string) {
+	request := decoded.(*RegisterRequest)
 
-		registerRegion, err := getKey(doc, "RegisterRegion")
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
-		if registerRegion != region {
-			return e.ReturnError(7, reason, errors.New("region does not match registration region"))
-		}
+	if request.RegisterRegion != request.Region {
+		return e.ReturnError(ErrorRegistrationFailed, errors.New("region does not match registration region"))
+	}
 
-		serialNo, err := getKey(doc, "SerialNumber")
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
+	// Validate given friend code, which must be this console's own so that gifts reach it.
+	err := validateDeviceCode(request.DeviceCode)
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
+	err = checkDeviceCodeOwner(request.DeviceCode, e.DeviceId())
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
 
-		// Validate given friend code.
-		userId, err := strconv.ParseUint(deviceCode, 10, 64)
-		if err != nil {
-			return e.ReturnError(7, reason, err)
-		}
-		if wiino.NWC24CheckUserID(userId) != 0 {
-			return e.ReturnError(7, reason, err)
-		}
+	// Ensure this console is who it claims to be.
+	rawDeviceCert, err := base64.StdEncoding.DecodeString(request.DeviceCert)
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
+	publicKey, err := verifyDeviceCert(rawDeviceCert, e.DeviceId())
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
 
-		// Generate a random 9-digit number, padding zeros as necessary.
-		accountId := fmt.Sprintf("%9d", rand.Intn(999999999))
-
-		// This is where it gets hairy.
-		// Generate a device token, 21 characters...
-		deviceToken := RandString(21)
-		// ...and then its md5, because the Wii sends this...
-		md5DeviceToken := fmt.Sprintf("%x", md5.Sum([]byte(deviceToken)))
-		// ...and then the sha256 of that md5.
-		// We'll store this in our database, as storing the md5 itself is effectively the token.
-		// It would not be good for security to directly store the token either.
-		// This is the hash of the md5 represented as a string, not individual byte values.
-		doublyHashedDeviceToken := fmt.Sprintf("%x", sha2562.Sum256([]byte(md5DeviceToken)))
-
-		// Insert all of our obtained values to the database..
-		stmt, err := db.Prepare(`INSERT INTO wiisoap.userbase (DeviceId, DeviceToken, AccountId, Region, Country, Language, SerialNo, DeviceCode)  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
-		if err != nil {
-			log.Printf("error preparing statement: %v\n", err)
-			return e.ReturnError(7, reason, errors.New("failed to prepare statement"))
+	// Insert all of our obtained values to the database..
+	account := Account{
+		DeviceId:        e.DeviceId(),
+		DeviceCode:      request.DeviceCode,
+		Region:          request.Region,
+		Country:         request.Country,
+		Language:        request.Language,
+		SerialNo:        request.SerialNumber,
+		DevicePublicKey: publicKey,
+	}
+	deviceToken, err := createAccount(&account)
+	if err == errAccountExists {
+		// Consoles which have unregistered, such as before a System Format, or whose device token can no longer
+		// be renewed keep their account and purchase history.
+		existing, lookupErr := store.GetAccountByDevice(e.DeviceId())
+		if lookupErr != nil {
+			return e.ReturnError(ErrorRegistrationFailed, lookupErr)
 		}
-		_, err = stmt.Exec(e.DeviceId(), doublyHashedDeviceToken, accountId, region, country, language, serialNo, deviceCode)
-		if err != nil {
-			// It's okay if this isn't a MySQL error, as perhaps other issues have come in.
-			if driverErr, ok := err.(*mysql.MySQLError); ok {
-				if driverErr.Number == 1062 {
-					return e.ReturnError(7, reason, errors.New("user already exists"))
-				}
+		if existing != nil && existing.RegistrationStatus() == DeviceStatusUnknown {
+			account.AccountId = existing.AccountId
+
+			var doublyHashedDeviceToken string
+			deviceToken, doublyHashedDeviceToken, err = account.issueDeviceToken()
+			if err == nil {
+				err = store.ReactivateAccount(account, doublyHashedDeviceToken)
 			}
-			log.Printf("error executing statement: %v\n", err)
-			return e.ReturnError(7, reason, errors.New("failed to execute db operation"))
 		}
+	}
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
 
-		fmt.Println("The request is valid! Responding...")
-		e.AddKVNode("AccountId", accountId)
-		e.AddKVNode("DeviceToken", deviceToken)
-		e.AddKVNode("DeviceTokenExpired", "false")
-		e.AddKVNode("Country", country)
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(RegisterResponse{
+		AccountId:          account.AccountId,
+		DeviceToken:        deviceToken,
+		DeviceTokenExpired: account.DeviceTokenExpired(),
+		Country:            request.Country,
 		// Optionally, one can send back DeviceCode and ExtAccountId to update on device.
 		// We send these back as-is regardless.
-		e.AddKVNode("ExtAccountId", "")
-		e.AddKVNode("DeviceCode", deviceCode)
-		break
+		ExtAccountId: "",
+		DeviceCode:   request.DeviceCode,
+	})
+}
 
-	case "Unregister":
-		// how abnormal... ;3
-		fmt.Println("The request is valid! Responding...")
-		break
+func iasSyncRegistration(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	deviceToken, err := renewDeviceToken(account)
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
 
-	default:
-		return false, "WiiSOAP can't handle this. Try again later or actually use a Wii instead of a computer."
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(SyncRegistrationResponse{
+		AccountId:          account.AccountId,
+		DeviceToken:        deviceToken,
+		DeviceTokenExpired: account.DeviceTokenExpired(),
+		Country:            account.Country,
+		ExtAccountId:       account.ExtAccountId,
+		DeviceCode:         account.DeviceCode,
+		DeviceStatus:       account.Status,
+		Currency:           "POINTS",
+	})
+}
+
+func iasUnregister(e *Envelope, _ interface{}, account *Account) (bool, string) {
+	// how abnormal... ;3
+
+	// The account is kept for its purchase history, but its device token can never be used again.
+	revokedTokenHash, err := revokedDeviceTokenHash()
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
+	}
+	err = store.UnregisterAccount(account.AccountId, revokedTokenHash)
+	if err != nil {
+		return e.ReturnError(ErrorRegistrationFailed, err)
 	}
 
-	return e.ReturnSuccess()
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(UnregisterResponse{})
 }
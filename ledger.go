@@ -0,0 +1,120 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TransactionType describes the reason a transaction was made, as the Wii displays it.
+type TransactionType string
+
+const (
+	TransactionPurchaseGame   TransactionType = "PURCHGAME"
+	TransactionPurchasePoints TransactionType = "PURCHPOINTS"
+	TransactionRedeemECard    TransactionType = "REDEEMECARD"
+	TransactionRefund         TransactionType = "REFUND"
+)
+
+var (
+	errInsufficientBalance = errors.New("account balance is too low for this transaction")
+	errPointsCapExceeded   = errors.New("account balance would exceed the points cap")
+)
+
+// pointsCap is the most points an account may hold, or 0 should there be no limit.
+var pointsCap int
+
+// LedgerEntry represents a single transaction against an account's points balance.
+// Entries are never modified after being recorded.
+type LedgerEntry struct {
+	TransactionId string
+	AccountId     string
+	Type          TransactionType
+	// Amount is positive for credits, and negative for debits.
+	Amount  int
+	TitleId string
+	// Date is in milliseconds since the epoch, as the Wii expects.
+	Date int64
+}
+
+// recordTransaction adjusts an account's balance by the given amount, recording it within the ledger.
+// Debits which would leave the account with a negative balance are rejected with errInsufficientBalance.
+func recordTransaction(accountId string, transactionType TransactionType, amount int, titleId string) (*LedgerEntry, error) {
+	entry, err := newLedgerEntry(accountId, transactionType, amount, titleId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.RecordTransaction(*entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// exceedsPointsCap returns whether crediting an account holding balance with amount would exceed pointsCap.
+func exceedsPointsCap(balance int, amount int) bool {
+	return pointsCap != 0 && balance+amount > pointsCap
+}
+
+// creditLimit returns the balance an entry may not take its account beyond, or 0 should it be unlimited.
+// Refunds only return points the account previously held, so are never limited.
+func creditLimit(entry LedgerEntry) int {
+	if entry.Amount <= 0 || entry.Type == TransactionRefund {
+		return 0
+	}
+	return pointsCap
+}
+
+// newLedgerEntry returns an entry dated now with a new transaction ID, without recording it.
+func newLedgerEntry(accountId string, transactionType TransactionType, amount int, titleId string) (*LedgerEntry, error) {
+	transactionId, err := randomDigits(10)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LedgerEntry{
+		TransactionId: transactionId,
+		AccountId:     accountId,
+		Type:          transactionType,
+		Amount:        amount,
+		TitleId:       titleId,
+		Date:          time.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+// Transaction returns the Transactions structure describing this entry.
+func (l *LedgerEntry) Transaction() Transactions {
+	return Transactions{
+		TransactionId: l.TransactionId,
+		Date:          fmt.Sprint(l.Date),
+		Type:          string(l.Type),
+	}
+}
+
+// PurchaseHistory returns the Transactions structure describing this entry within an account's purchase history.
+// Unlike Transaction, it includes the title and the points credited or debited.
+func (l *LedgerEntry) PurchaseHistory() Transactions {
+	transaction := l.Transaction()
+	transaction.TitleId = l.TitleId
+	transaction.Points = l.Amount
+	return transaction
+}
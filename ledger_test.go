@@ -0,0 +1,188 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordTransaction(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	_, err := recordTransaction(account.AccountId, TransactionRedeemECard, 500, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recordTransaction(account.AccountId, TransactionPurchaseGame, -500, "0001000146414445")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 0 {
+		t.Errorf("balance is %d, want 0", balance)
+	}
+}
+
+func TestRecordTransactionFree(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	// Free titles leave the balance unchanged, but must still be recorded.
+	_, err := recordTransaction(account.AccountId, TransactionPurchaseGame, 0, "0001000146414445")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLedger(t, account, 0, TransactionPurchaseGame)
+}
+
+func TestRecordTransactionInsufficientBalance(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	_, err := recordTransaction(account.AccountId, TransactionRedeemECard, 500, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = recordTransaction(account.AccountId, TransactionPurchaseGame, -501, "0001000146414445")
+	if err != errInsufficientBalance {
+		t.Fatalf("debiting beyond the balance returned %v, want %v", err, errInsufficientBalance)
+	}
+	checkLedger(t, account, 500, TransactionRedeemECard)
+}
+
+func TestRecordTransactionPointsCap(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	previous := pointsCap
+	pointsCap = 1000
+	defer func() { pointsCap = previous }()
+
+	_, err := recordTransaction(account.AccountId, TransactionRedeemECard, 1001, "")
+	if err != errPointsCapExceeded {
+		t.Fatalf("crediting beyond the cap returned %v, want %v", err, errPointsCapExceeded)
+	}
+	checkLedger(t, account, 0)
+}
+
+func TestRecordTransactionUnknownAccount(t *testing.T) {
+	defer setupTestStore(t)()
+
+	for _, amount := range []int{500, -500} {
+		_, err := recordTransaction("9999999999", TransactionRedeemECard, amount, "")
+		if err != errUnknownAccount {
+			t.Errorf("recording %d points for an unknown account returned %v, want %v", amount, err, errUnknownAccount)
+		}
+	}
+}
+
+func TestPurchaseTitleIsAtomic(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	_, err := recordTransaction(account.AccountId, TransactionRedeemECard, 500, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	purchase := func(ticketId uint64, amount int) error {
+		entry, err := newLedgerEntry(account.AccountId, TransactionPurchaseGame, -amount, "0001000146414445")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.PurchaseTitle(OwnedTitle{TicketId: ticketId, TitleId: 0x0001000146414445, PurchaseDate: entry.Date, TransactionId: entry.TransactionId}, *entry)
+	}
+
+	// Titles which cannot be paid for are not granted.
+	err = purchase(1, 501)
+	if err != errInsufficientBalance {
+		t.Fatalf("purchasing beyond the balance returned %v, want %v", err, errInsufficientBalance)
+	}
+	owned, err := store.GetOwnedTitle(account.AccountId, 0x0001000146414445)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owned != nil {
+		t.Error("title was granted without being paid for")
+	}
+
+	err = purchase(1, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor are titles which cannot be granted paid for.
+	err = purchase(2, 200)
+	if err != errTitleAlreadyOwned {
+		t.Fatalf("purchasing an owned title returned %v, want %v", err, errTitleAlreadyOwned)
+	}
+	balance, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 300 {
+		t.Errorf("balance is %d, want 300", balance)
+	}
+}
+
+func TestListTransactionsOrder(t *testing.T) {
+	defer setupTestStore(t)()
+	account := setupTestAccount(t)
+
+	// Purchases and their refunds are often recorded within the same millisecond.
+	for _, transactionId := range []string{"2000000000", "3000000000", "1000000000"} {
+		err := store.RecordTransaction(LedgerEntry{
+			TransactionId: transactionId,
+			AccountId:     account.AccountId,
+			Type:          TransactionRedeemECard,
+			Amount:        100,
+			Date:          1600000000000,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := store.RecordTransaction(LedgerEntry{
+		TransactionId: "0000000001",
+		AccountId:     account.AccountId,
+		Type:          TransactionRedeemECard,
+		Amount:        100,
+		Date:          1600000000001,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.ListTransactions(account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transactionIds []string
+	for _, entry := range entries {
+		transactionIds = append(transactionIds, entry.TransactionId)
+	}
+	expected := "[0000000001 3000000000 2000000000 1000000000]"
+	if fmt.Sprint(transactionIds) != expected {
+		t.Errorf("entries are ordered %v, want %s", transactionIds, expected)
+	}
+}
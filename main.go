@@ -18,23 +18,26 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/xml"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 )
 
 const (
 	// SharedChallenge represents a static value to this nonsensical challenge response system.
 	// The given challenge must be 11 characters or less. Contents do not matter.
 	SharedChallenge = "NintyWhyPls"
-)
 
-var db *sql.DB
+	// FaultCodeClient indicates a fault was caused by the request, such as an unknown action.
+	FaultCodeClient = "soapenv:Client"
+	// FaultCodeServer indicates a fault was caused by WiiSOAP itself.
+	FaultCodeServer = "soapenv:Server"
+)
 
 // checkError makes error handling not as ugly and inefficient.
 func checkError(err error) {
@@ -47,6 +50,12 @@ func main() {
 	// Initial Start.
 	fmt.Println("WiiSOAP 0.2.6 Kawauso\n[i] Reading the Config...")
 
+	// Listing actions requires nothing, not even the config.
+	if len(os.Args) > 1 && os.Args[1] == "actions" {
+		runActionsCommand()
+		return
+	}
+
 	// Check the Config.
 	ioconfig, err := ioutil.ReadFile("./config.xml")
 	checkError(err)
@@ -56,14 +65,53 @@ func main() {
 
 	fmt.Println("[i] Initializing core...")
 
-	// Start SQL.
-	db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s", CON.SQLUser, CON.SQLPass, CON.SQLAddress, CON.SQLDB))
+	// Open the database.
+	store, err = openStore(CON)
 	checkError(err)
 
-	// Close SQL after everything else is done.
-	defer db.Close()
-	err = db.Ping()
+	// Close the database after everything else is done.
+	defer store.Close()
+
+	// Subcommands only require the database.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrateCommand(os.Args[2:])
+		checkError(err)
+		return
+	}
+
+	// Ensure the schema is up to date before handling anything.
+	err = store.Migrate(latestSchemaVersion())
+	checkError(err)
+
+	if len(os.Args) > 1 && os.Args[1] == "expire-tokens" {
+		err = runExpireTokensCommand(os.Args[2:])
+		checkError(err)
+		return
+	}
+
+	// Cards are both minted and redeemed with the same key.
+	err = loadECardKey(CON)
+	checkError(err)
+	if len(os.Args) > 1 && os.Args[1] == "generate-ecards" {
+		err = runGenerateECardsCommand(os.Args[2:])
+		checkError(err)
+		return
+	}
+
+	// Load everything necessary to issue tickets.
+	err = loadTicketKeys(CON)
+	checkError(err)
+	err = loadDeviceCertIssuer(CON)
+	checkError(err)
+	err = loadSystemTitles(CON)
+	checkError(err)
+	err = loadContentStore(CON)
+	checkError(err)
+	err = loadPaymentProvider(CON)
 	checkError(err)
+	deviceTokenLifetime = time.Duration(CON.DeviceTokenLifetime) * 24 * time.Hour
+	deviceTokenRenewalPeriod = time.Duration(CON.DeviceTokenRenewalPeriod) * 24 * time.Hour
+	pointsCap = CON.PointsCap
 
 	// Start the HTTP server.
 	fmt.Printf("Starting HTTP connection (%s)...\nNot using the usual port for HTTP?\nBe sure to use a proxy, otherwise the Wii can't connect!\n", CON.Address)
@@ -72,40 +120,51 @@ func main() {
 	// However, semantically, it feels proper.
 	http.HandleFunc("/ecs/services/ECommerceSOAP", commonHandler)
 	http.HandleFunc("/ias/services/IdentityAuthenticationSOAP", commonHandler)
+	http.HandleFunc("/cas/services/CatalogingSOAP", commonHandler)
+	http.HandleFunc("/nus/services/NetUpdateSOAP", commonHandler)
+	http.HandleFunc(contentPrefix, contentHandler)
 	log.Fatal(http.ListenAndServe(CON.Address, nil))
 
 	// From here on out, all special cool things should go into their respective handler function.
 }
 
 func commonHandler(w http.ResponseWriter, r *http.Request) {
+	// SOAP requests are only ever POSTed.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		printError(w, http.StatusMethodNotAllowed, FaultCodeClient, "Method not allowed.", "")
+		return
+	}
+
 	// Figure out the action to handle via header.
 	service, action := parseAction(r.Header.Get("SOAPAction"))
 	if service == "" || action == "" {
-		printError(w, "WiiSOAP can't handle this. Try again later or actually use a Wii instead of a computer.")
+		printError(w, http.StatusInternalServerError, FaultCodeClient, "WiiSOAP can't handle this. Try again later or actually use a Wii instead of a computer.", "")
 		return
 	}
 
-	// Verify this is a service type we know.
-	switch service {
-	case "ecs":
-	case "ias":
-		break
-	default:
-		printError(w, "Unsupported service type...")
+	// Verify this is an action we know.
+	if actions[service] == nil {
+		printError(w, http.StatusInternalServerError, FaultCodeClient, "Unsupported service type...", service)
+		return
+	}
+	handler := findAction(service, action)
+	if handler == nil {
+		printError(w, http.StatusInternalServerError, FaultCodeClient, "WiiSOAP can't handle this. Try again later or actually use a Wii instead of a computer.", service+"/"+action)
 		return
 	}
 
 	fmt.Println("[!] Incoming " + strings.ToUpper(service) + " request - handling for " + action)
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		printError(w, "Error reading request body...")
+		printError(w, http.StatusInternalServerError, FaultCodeServer, "Error reading request body...", err.Error())
 		return
 	}
 
 	// Tidy up parsed document for easier usage going forward.
 	doc, err := normalise(service, action, strings.NewReader(string(body)))
 	if err != nil {
-		printError(w, "Error interpreting request body: "+err.Error())
+		printError(w, http.StatusInternalServerError, FaultCodeClient, "Error interpreting request body...", err.Error())
 		return
 	}
 
@@ -117,30 +176,34 @@ func commonHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract shared values from this request.
 	err = envelope.ObtainCommon(doc)
 	if err != nil {
-		printError(w, "Error handling request body: "+err.Error())
+		printError(w, http.StatusInternalServerError, FaultCodeClient, "Error handling request body...", err.Error())
 		return
 	}
 
-	var successful bool
-	var result string
-	if service == "ias" {
-		successful, result = iasHandler(envelope, doc)
-	} else if service == "ecs" {
-		successful, result = ecsHandler(envelope, doc)
-	}
-
+	successful, result := handler.handle(&envelope, doc)
 	if successful {
 		// Write returned with proper Content-Type
 		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 		w.Write([]byte(result))
 	} else {
-		printError(w, result)
+		// Handlers only fail outright when their response could not be created.
+		printError(w, http.StatusInternalServerError, FaultCodeServer, result, service+"/"+action)
 	}
 
 	fmt.Println("[!] End of " + strings.ToUpper(service) + " Request.\n")
 }
 
-func printError(w http.ResponseWriter, reason string) {
-	http.Error(w, reason, http.StatusInternalServerError)
+// printError responds with a SOAP fault. SOAP 1.1 requires faults to be sent with a 500 status,
+// with the exception of requests we refuse before reading them as SOAP, such as those with the wrong method.
+func printError(w http.ResponseWriter, status int, faultCode string, reason string, detail string) {
+	contents, err := xml.Marshal(NewFault(faultCode, reason, detail))
+	if err != nil {
+		http.Error(w, reason, status)
+	} else {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(xml.Header + string(contents)))
+	}
+
 	fmt.Println("Failed to handle request: " + reason)
 }
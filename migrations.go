@@ -0,0 +1,411 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration describes a single change to the database schema.
+// Statements must be understood by both MySQL and SQLite, unless SQLiteUp and SQLiteDown are given.
+type Migration struct {
+	Version     int
+	Description string
+	Up          []string
+	Down        []string
+	// SQLiteUp and SQLiteDown replace Up and Down on SQLite, for the few changes it cannot express as MySQL does.
+	SQLiteUp   []string
+	SQLiteDown []string
+}
+
+// migrations lists every schema change, in the order they must be applied.
+// Features adding or changing tables should append a new migration, never edit an existing one.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create userbase",
+		Up: []string{
+			// This token should be considered a secret, so after generation only the sha256sum of the md5 the Wii sends is inserted.
+			`CREATE TABLE userbase (
+				DeviceId varchar(10) NOT NULL,
+				DeviceToken varchar(64) NOT NULL,
+				AccountId varchar(9) NOT NULL,
+				Region varchar(2) NOT NULL,
+				Country varchar(2) NOT NULL,
+				Language varchar(2) NOT NULL,
+				SerialNo varchar(11) NOT NULL,
+				DeviceCode varchar(16) NOT NULL,
+				PRIMARY KEY (AccountId),
+				UNIQUE (DeviceId),
+				UNIQUE (DeviceToken)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE userbase`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "Create titlekeys",
+		Up: []string{
+			// Title keys are stored decrypted, in hex.
+			`CREATE TABLE titlekeys (
+				TitleId varchar(16) NOT NULL,
+				TitleKey varchar(32) NOT NULL,
+				PRIMARY KEY (TitleId)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE titlekeys`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "Add points balances and the ledger",
+		Up: []string{
+			`ALTER TABLE userbase ADD COLUMN Points int NOT NULL DEFAULT 0`,
+			// Amount is positive for credits and negative for debits, and Date is in milliseconds since the epoch.
+			`CREATE TABLE ledger (
+				TransactionId varchar(10) NOT NULL,
+				AccountId varchar(9) NOT NULL,
+				Type varchar(16) NOT NULL,
+				Amount int NOT NULL,
+				TitleId varchar(16) NOT NULL DEFAULT '',
+				Date bigint NOT NULL,
+				PRIMARY KEY (TransactionId)
+			)`,
+			`CREATE INDEX ledger_AccountId_index ON ledger (AccountId)`,
+		},
+		Down: []string{
+			`DROP TABLE ledger`,
+			`ALTER TABLE userbase DROP COLUMN Points`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "Create owned_titles",
+		Up: []string{
+			`CREATE TABLE owned_titles (
+				TicketId varchar(20) NOT NULL,
+				AccountId varchar(9) NOT NULL,
+				TitleId varchar(16) NOT NULL,
+				Version int NOT NULL DEFAULT 0,
+				RevokeDate bigint NOT NULL DEFAULT 0,
+				PurchaseDate bigint NOT NULL,
+				TransactionId varchar(10) NOT NULL,
+				PRIMARY KEY (TicketId),
+				UNIQUE (AccountId, TitleId)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE owned_titles`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "Create catalog",
+		Up: []string{
+			// Countries and Ratings are comma-separated lists.
+			`CREATE TABLE catalog (
+				TitleId varchar(16) NOT NULL,
+				Name varchar(64) NOT NULL,
+				Price int NOT NULL,
+				Region varchar(3) NOT NULL,
+				Countries varchar(255) NOT NULL DEFAULT '',
+				Platform varchar(16) NOT NULL,
+				Version int NOT NULL DEFAULT 0,
+				ReleaseDate bigint NOT NULL,
+				Size bigint NOT NULL,
+				Ratings varchar(255) NOT NULL DEFAULT '',
+				PRIMARY KEY (TitleId)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE catalog`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "Add account status",
+		Up: []string{
+			`ALTER TABLE userbase ADD COLUMN Status varchar(1) NOT NULL DEFAULT 'R'`,
+		},
+		Down: []string{
+			`ALTER TABLE userbase DROP COLUMN Status`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "Add external account IDs and device token expiry",
+		Up: []string{
+			`ALTER TABLE userbase ADD COLUMN ExtAccountId varchar(32) NOT NULL DEFAULT ''`,
+			// DeviceTokenExpiry is in milliseconds since the epoch, or 0 should the token never expire.
+			`ALTER TABLE userbase ADD COLUMN DeviceTokenExpiry bigint NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE userbase DROP COLUMN DeviceTokenExpiry`,
+			`ALTER TABLE userbase DROP COLUMN ExtAccountId`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "Add device token issue times",
+		Up: []string{
+			// DeviceTokenIssued is in milliseconds since the epoch, or 0 for tokens issued before it was recorded.
+			`ALTER TABLE userbase ADD COLUMN DeviceTokenIssued bigint NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE userbase DROP COLUMN DeviceTokenIssued`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "Add device public keys",
+		Up: []string{
+			// DevicePublicKey is stored in hex, and is empty for accounts registered before device certificates were verified.
+			`ALTER TABLE userbase ADD COLUMN DevicePublicKey varchar(120) NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE userbase DROP COLUMN DevicePublicKey`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "Create ecards",
+		Up: []string{
+			// CodeHash is the sha256 of the card's code, and times are in milliseconds since the epoch.
+			`CREATE TABLE ecards (
+				CodeHash varchar(64) NOT NULL,
+				Value int NOT NULL,
+				Currency varchar(8) NOT NULL DEFAULT 'POINTS',
+				Expiry bigint NOT NULL DEFAULT 0,
+				RedeemedBy varchar(9) NOT NULL DEFAULT '',
+				RedeemedAt bigint NOT NULL DEFAULT 0,
+				PRIMARY KEY (CodeHash)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE ecards`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "Create gifts",
+		Up: []string{
+			// Amount is the points paid by the sender, and times are in milliseconds since the epoch.
+			`CREATE TABLE gifts (
+				GiftId varchar(10) NOT NULL,
+				SenderAccountId varchar(9) NOT NULL,
+				SenderDeviceCode varchar(16) NOT NULL,
+				RecipientAccountId varchar(9) NOT NULL,
+				TitleId varchar(16) NOT NULL,
+				Amount int NOT NULL,
+				TransactionId varchar(10) NOT NULL,
+				Message varchar(255) NOT NULL DEFAULT '',
+				Status varchar(1) NOT NULL DEFAULT 'P',
+				CreatedAt bigint NOT NULL,
+				ResolvedAt bigint NOT NULL DEFAULT 0,
+				PRIMARY KEY (GiftId)
+			)`,
+			`CREATE INDEX gifts_RecipientAccountId_index ON gifts (RecipientAccountId)`,
+		},
+		Down: []string{
+			`DROP TABLE gifts`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "Widen userbase.Region to match the catalog",
+		Up: []string{
+			// Regions are three characters, such as "USA", yet userbase was created with room for two.
+			// Both statements may safely run again should the migration fail partway through.
+			`ALTER TABLE userbase MODIFY Region varchar(3) NOT NULL`,
+			// Databases which truncated rather than rejecting regions are restored to their full codes.
+			`UPDATE userbase SET Region = CASE Region
+				WHEN 'US' THEN 'USA'
+				WHEN 'EU' THEN 'EUR'
+				WHEN 'JP' THEN 'JPN'
+				WHEN 'KO' THEN 'KOR'
+				ELSE Region
+			END`,
+		},
+		Down: []string{
+			`UPDATE userbase SET Region = SUBSTR(Region, 1, 2)`,
+			`ALTER TABLE userbase MODIFY Region varchar(2) NOT NULL`,
+		},
+		// SQLite does not enforce the length of varchar columns, so only the stored regions need changing.
+		SQLiteUp: []string{
+			`UPDATE userbase SET Region = CASE Region
+				WHEN 'US' THEN 'USA'
+				WHEN 'EU' THEN 'EUR'
+				WHEN 'JP' THEN 'JPN'
+				WHEN 'KO' THEN 'KOR'
+				ELSE Region
+			END`,
+		},
+		SQLiteDown: []string{
+			`UPDATE userbase SET Region = SUBSTR(Region, 1, 2)`,
+		},
+	},
+}
+
+// latestSchemaVersion returns the version of the newest known migration.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// prepareMigrations ensures the schema_migrations table exists.
+// Databases created from the old database.sql already contain userbase, so they begin at version 1.
+func (s *sqlStore) prepareMigrations() error {
+	if _, err := s.db.Exec(`SELECT 1 FROM schema_migrations`); err == nil {
+		return nil
+	}
+
+	_, err := s.db.Exec(`CREATE TABLE schema_migrations (
+		Version int NOT NULL,
+		AppliedAt bigint NOT NULL,
+		PRIMARY KEY (Version)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(`SELECT 1 FROM userbase`); err == nil {
+		log.Println("Existing userbase found, assuming schema version 1.")
+		return s.setMigrated(1, true)
+	}
+	return nil
+}
+
+// setMigrated records whether a migration has been applied.
+func (s *sqlStore) setMigrated(version int, applied bool) error {
+	var err error
+	if applied {
+		_, err = s.db.Exec(`INSERT INTO schema_migrations (Version, AppliedAt) VALUES (?, ?)`, version, time.Now().Unix())
+	} else {
+		_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE Version = ?`, version)
+	}
+	return err
+}
+
+func (s *sqlStore) SchemaVersion() (int, error) {
+	err := s.prepareMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	_, err = s.queryRow(`SELECT COALESCE(MAX(Version), 0) FROM schema_migrations`, nil, &version)
+	return version, err
+}
+
+func (s *sqlStore) Migrate(target int) error {
+	if target < 0 || target > latestSchemaVersion() {
+		return fmt.Errorf("unknown schema version %d", target)
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	// Statements are not wrapped within a transaction, as MySQL implicitly commits schema changes regardless.
+	for _, migration := range migrations {
+		if migration.Version > current && migration.Version <= target {
+			fmt.Printf("[i] Applying migration %d: %s\n", migration.Version, migration.Description)
+			err = s.runMigration(migration.Version, s.migrationStatements(migration, true), true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= current && migration.Version > target {
+			fmt.Printf("[i] Reverting migration %d: %s\n", migration.Version, migration.Description)
+			err = s.runMigration(migration.Version, s.migrationStatements(migration, false), false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrationStatements returns the statements applying or reverting a migration on this database.
+func (s *sqlStore) migrationStatements(migration Migration, applied bool) []string {
+	if s.sqlite && migration.SQLiteUp != nil {
+		if applied {
+			return migration.SQLiteUp
+		}
+		return migration.SQLiteDown
+	}
+	if applied {
+		return migration.Up
+	}
+	return migration.Down
+}
+
+// runMigration executes the given statements, recording the migration as applied or reverted afterwards.
+func (s *sqlStore) runMigration(version int, statements []string, applied bool) error {
+	for _, statement := range statements {
+		_, err := s.db.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+	}
+
+	return s.setMigrated(version, applied)
+}
+
+// runMigrateCommand handles the "migrate" subcommand, such as "WiiSOAP migrate status".
+func runMigrateCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: WiiSOAP migrate status|up|down")
+	}
+
+	current, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		for _, migration := range migrations {
+			state := "pending"
+			if migration.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("%3d  %-8s %s\n", migration.Version, state, migration.Description)
+		}
+		return nil
+	case "up":
+		return store.Migrate(latestSchemaVersion())
+	case "down":
+		if current == 0 {
+			return errors.New("no migrations have been applied")
+		}
+		return store.Migrate(current - 1)
+	default:
+		return errors.New("usage: WiiSOAP migrate status|up|down")
+	}
+}
@@ -0,0 +1,139 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// systemTitles maps a region to the system titles and versions consoles within it should have.
+	systemTitles map[string][]SystemTitle
+	// contentPrefixURL is where consoles download title contents from.
+	contentPrefixURL string
+)
+
+// loadSystemTitles reads the configured system titles for each region.
+func loadSystemTitles(config Config) error {
+	systemTitles = make(map[string][]SystemTitle)
+	for _, region := range config.SystemTitles {
+		for _, title := range region.Titles {
+			if _, err := parseTitleId(title.TitleId); err != nil {
+				return fmt.Errorf("invalid system title %s for region %s: %v", title.TitleId, region.Name, err)
+			}
+		}
+		systemTitles[region.Name] = region.Titles
+	}
+
+	contentPrefixURL = config.ContentPrefixURL
+	return nil
+}
+
+// findSystemTitle returns the system title within a region with the given title ID, or nil if there is none.
+func findSystemTitle(region string, titleId string) *SystemTitle {
+	for _, title := range systemTitles[region] {
+		if strings.EqualFold(title.TitleId, titleId) {
+			return &title
+		}
+	}
+	return nil
+}
+
+func init() {
+	// All NUS-related requests embed NUSRequest.
+	registerService("nus",
+		Action{Name: "GetSystemUpdate", Request: GetSystemUpdateRequest{}, Handler: nusGetSystemUpdate},
+		Action{Name: "GetSystemTitleHash", Request: GetSystemTitleHashRequest{}, Handler: nusGetSystemTitleHash},
+		Action{Name: "GetSystemCommonETicket", Request: GetSystemCommonETicketRequest{}, Handler: nusGetSystemCommonETicket},
+	)
+}
+
+func nusGetSystemUpdate(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*GetSystemUpdateRequest)
+
+	response := GetSystemUpdateResponse{
+		ContentPrefixURL:         contentPrefixURL,
+		UncachedContentPrefixURL: contentPrefixURL,
+		UploadAuditData:          1,
+	}
+	for _, title := range systemTitles[request.RegionId] {
+		response.TitleVersions = append(response.TitleVersions, TitleVersion{
+			TitleId: title.TitleId,
+			Version: title.Version,
+			FsSize:  title.Size,
+		})
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(response)
+}
+
+func nusGetSystemTitleHash(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*GetSystemTitleHashRequest)
+
+	// The console compares this against its last known hash to decide whether to call GetSystemUpdate.
+	hash := md5.New()
+	for _, title := range systemTitles[request.RegionId] {
+		fmt.Fprintf(hash, "%s:%d;", title.TitleId, title.Version)
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(GetSystemTitleHashResponse{
+		TitleHash: fmt.Sprintf("%X", hash.Sum(nil)),
+	})
+}
+
+func nusGetSystemCommonETicket(e *Envelope, decoded interface{}, account *Account) (bool, string) {
+	request := decoded.(*GetSystemCommonETicketRequest)
+
+	var tickets []string
+	for _, titleIdString := range request.TitleIds {
+		title := findSystemTitle(request.RegionId, titleIdString)
+		if title == nil {
+			return e.ReturnError(ErrorUnknownSystemTitle, errors.New("not a system title for this region"))
+		}
+
+		titleId, err := parseTitleId(title.TitleId)
+		if err != nil {
+			return e.ReturnError(ErrorInvalidRequest, err)
+		}
+		titleKey, err := getTitleKey(titleId)
+		if err != nil {
+			return e.ReturnError(ErrorUnknownSystemTitle, err)
+		}
+
+		// Common tickets are not personalised to any console.
+		ticket := NewTicket(0, titleId, 0, titleKey)
+		ticket.TitleVersion = title.Version
+		contents, err := ticket.Bytes()
+		if err != nil {
+			return e.ReturnError(ErrorUnknownSystemTitle, err)
+		}
+		tickets = append(tickets, base64.StdEncoding.EncodeToString(contents))
+	}
+
+	fmt.Println("The request is valid! Responding...")
+	return e.ReturnSuccess(GetSystemCommonETicketResponse{
+		CommonETickets: tickets,
+		Certs:          encodedCertChain(),
+	})
+}
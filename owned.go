@@ -0,0 +1,56 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+)
+
+// OwnedTitle represents a ticket issued to an account.
+type OwnedTitle struct {
+	TicketId uint64
+	TitleId  uint64
+	Version  uint16
+	// RevokeDate is 0 unless the ticket has been revoked, in milliseconds since the epoch.
+	RevokeDate int64
+	// PurchaseDate is in milliseconds since the epoch.
+	PurchaseDate  int64
+	TransactionId string
+}
+
+// Ticket returns the ticket for this owned title, personalised to the given console.
+func (o *OwnedTitle) Ticket(consoleId uint32) (*Ticket, error) {
+	titleKey, err := getTitleKey(o.TitleId)
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewTicket(o.TicketId, o.TitleId, consoleId, titleKey)
+	t.TitleVersion = o.Version
+	return t, nil
+}
+
+// Tickets returns the Tickets structure describing this owned title.
+func (o *OwnedTitle) Tickets() Tickets {
+	return Tickets{
+		TicketId:   fmt.Sprint(o.TicketId),
+		TitleId:    fmt.Sprintf("%016x", o.TitleId),
+		RevokeDate: o.RevokeDate,
+		Version:    o.Version,
+	}
+}
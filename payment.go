@@ -0,0 +1,202 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Payment describes money being paid for points, as requested by a console.
+type Payment struct {
+	AccountId string `json:"account_id"`
+	Points    int    `json:"points"`
+	// Amount and Currency are the price shown to the user, such as 10.00 USD.
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+	// Method is the payment method chosen by the console, such as CCARD.
+	Method string `json:"method"`
+}
+
+// PaymentProvider takes payment for points. Handlers never see payment details beyond a Payment,
+// leaving card handling entirely to the provider.
+type PaymentProvider interface {
+	// Authorize reserves payment, returning an ID identifying the authorization.
+	Authorize(payment Payment) (string, error)
+	// Capture takes the money reserved by a previous authorization.
+	Capture(authorizationId string) error
+	// Void releases a previous authorization which will never be captured.
+	Void(authorizationId string) error
+	// Refund returns the money taken by a previous capture.
+	Refund(authorizationId string) error
+}
+
+// paymentProvider is the PaymentProvider used by PurchasePoints, or nil if purchasing points is disabled.
+var paymentProvider PaymentProvider
+
+// pointsPrices lists the points which may be purchased via PurchasePoints, and what is charged for them.
+var pointsPrices []PointsPrice
+
+var (
+	errPaymentsDisabled = errors.New("purchasing points is disabled")
+	errUnknownPrice     = errors.New("no price is configured for these points")
+	errPriceMismatch    = errors.New("price does not match the configured price")
+)
+
+// loadPaymentProvider selects the payment provider and prices within the config.
+func loadPaymentProvider(config Config) error {
+	for _, price := range config.PointsPrices {
+		if price.Points <= 0 || price.Amount == "" || price.Currency == "" {
+			return fmt.Errorf("points price %+v must have points, an amount and a currency", price)
+		}
+	}
+	pointsPrices = config.PointsPrices
+	if config.PaymentProvider != "" && len(pointsPrices) == 0 {
+		return errors.New("purchasing points requires PointsPrices")
+	}
+
+	switch config.PaymentProvider {
+	case "":
+		paymentProvider = nil
+	case "free":
+		paymentProvider = freePaymentProvider{}
+	case "http":
+		if config.PaymentGatewayURL == "" {
+			return errors.New("the http payment provider requires PaymentGatewayURL")
+		}
+		paymentProvider = &httpPaymentProvider{
+			url:    strings.TrimSuffix(config.PaymentGatewayURL, "/"),
+			client: &http.Client{Timeout: 30 * time.Second},
+		}
+	default:
+		return fmt.Errorf("unknown payment provider %s", config.PaymentProvider)
+	}
+	return nil
+}
+
+// findPointsPrice returns the price for the points a console asked to purchase, or nil if none is configured.
+// The item ID chosen within the Shop is preferred, falling back to the points and currency requested.
+func findPointsPrice(itemId string, points int, currency string) *PointsPrice {
+	for _, price := range pointsPrices {
+		if itemId != "" && price.ItemId == itemId {
+			return &price
+		}
+		if itemId == "" && price.Points == points && price.Currency == currency {
+			return &price
+		}
+	}
+	return nil
+}
+
+// freePaymentProvider approves every payment without taking any money,
+// allowing community servers and testers to hand out points.
+type freePaymentProvider struct{}
+
+func (freePaymentProvider) Authorize(payment Payment) (string, error) {
+	authorizationId, err := randomDigits(16)
+	if err != nil {
+		return "", err
+	}
+	return "free-" + authorizationId, nil
+}
+
+func (freePaymentProvider) Capture(authorizationId string) error {
+	return nil
+}
+
+func (freePaymentProvider) Void(authorizationId string) error {
+	return nil
+}
+
+func (freePaymentProvider) Refund(authorizationId string) error {
+	return nil
+}
+
+// httpPaymentProvider forwards payments to a gateway speaking JSON over HTTP.
+// Each operation is a POST to /authorize, /capture, /void or /refund beneath the gateway's URL,
+// with any status other than 200 OK being a failure described by its error field.
+type httpPaymentProvider struct {
+	url    string
+	client *http.Client
+}
+
+// gatewayAuthorization is exchanged with a payment gateway to identify an authorization.
+type gatewayAuthorization struct {
+	AuthorizationId string `json:"authorization_id"`
+}
+
+// gatewayError is returned by a payment gateway alongside a failing status.
+type gatewayError struct {
+	Error string `json:"error"`
+}
+
+func (h *httpPaymentProvider) Authorize(payment Payment) (string, error) {
+	var authorization gatewayAuthorization
+	err := h.post("authorize", payment, &authorization)
+	if err != nil {
+		return "", err
+	}
+	if authorization.AuthorizationId == "" {
+		return "", errors.New("payment gateway did not return an authorization ID")
+	}
+	return authorization.AuthorizationId, nil
+}
+
+func (h *httpPaymentProvider) Capture(authorizationId string) error {
+	return h.post("capture", gatewayAuthorization{authorizationId}, nil)
+}
+
+func (h *httpPaymentProvider) Void(authorizationId string) error {
+	return h.post("void", gatewayAuthorization{authorizationId}, nil)
+}
+
+func (h *httpPaymentProvider) Refund(authorizationId string) error {
+	return h.post("refund", gatewayAuthorization{authorizationId}, nil)
+}
+
+// post sends request to the given operation, decoding the response into response if non-nil.
+func (h *httpPaymentProvider) post(operation string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url+"/"+operation, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure gatewayError
+		if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("payment gateway failed to %s: %s", operation, failure.Error)
+	}
+
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,313 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockGateway is a payment gateway for use with httpPaymentProvider.
+// It approves every payment unless told otherwise, and enforces that authorizations only move forwards.
+type mockGateway struct {
+	mutex sync.Mutex
+	// states maps an authorization ID to either "authorized", "captured", "voided" or "refunded".
+	states map[string]string
+	// payments maps an authorization ID to the payment it authorized.
+	payments map[string]Payment
+
+	// failCapture declines every capture.
+	failCapture bool
+	// onCapture is called before a capture succeeds, if set.
+	onCapture func()
+}
+
+func newMockGateway() *mockGateway {
+	return &mockGateway{
+		states:   map[string]string{},
+		payments: map[string]Payment{},
+	}
+}
+
+// transition moves an authorization from one state to another, failing if it is not within the expected state.
+func (m *mockGateway) transition(authorizationId string, from string, to string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.states[authorizationId] != from {
+		return fmt.Errorf("authorization %s is not %s", authorizationId, from)
+	}
+	m.states[authorizationId] = to
+	return nil
+}
+
+// state returns the state of the only authorization made, failing the test should there be any other number.
+func (m *mockGateway) state(t *testing.T) (string, Payment) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.states) != 1 {
+		t.Fatalf("gateway holds %d authorizations, want 1", len(m.states))
+	}
+	for authorizationId, state := range m.states {
+		return state, m.payments[authorizationId]
+	}
+	return "", Payment{}
+}
+
+func (m *mockGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeGatewayResponse(w, http.StatusMethodNotAllowed, gatewayError{"method not allowed"})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/authorize":
+		var payment Payment
+		if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+			writeGatewayResponse(w, http.StatusBadRequest, gatewayError{err.Error()})
+			return
+		}
+
+		m.mutex.Lock()
+		authorizationId := fmt.Sprintf("auth-%d", len(m.states)+1)
+		m.states[authorizationId] = "authorized"
+		m.payments[authorizationId] = payment
+		m.mutex.Unlock()
+
+		writeGatewayResponse(w, http.StatusOK, gatewayAuthorization{authorizationId})
+	case "/capture", "/void", "/refund":
+		var authorization gatewayAuthorization
+		if err := json.NewDecoder(r.Body).Decode(&authorization); err != nil {
+			writeGatewayResponse(w, http.StatusBadRequest, gatewayError{err.Error()})
+			return
+		}
+
+		var err error
+		switch r.URL.Path {
+		case "/capture":
+			if m.failCapture {
+				writeGatewayResponse(w, http.StatusPaymentRequired, gatewayError{"card declined"})
+				return
+			}
+			if m.onCapture != nil {
+				m.onCapture()
+			}
+			err = m.transition(authorization.AuthorizationId, "authorized", "captured")
+		case "/void":
+			err = m.transition(authorization.AuthorizationId, "authorized", "voided")
+		default:
+			err = m.transition(authorization.AuthorizationId, "captured", "refunded")
+		}
+		if err != nil {
+			writeGatewayResponse(w, http.StatusConflict, gatewayError{err.Error()})
+			return
+		}
+
+		writeGatewayResponse(w, http.StatusOK, authorization)
+	default:
+		writeGatewayResponse(w, http.StatusNotFound, gatewayError{"unknown operation"})
+	}
+}
+
+// writeGatewayResponse writes a JSON response with the given status.
+func writeGatewayResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// setupTestStore installs an empty SQLite store, returning a function closing it and restoring the previous one.
+func setupTestStore(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "wiisoap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSQLiteStore(Config{SQLitePath: filepath.Join(dir, "wiisoap.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Migrate(latestSchemaVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := store
+	store = s
+	return func() {
+		store = previous
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// setupTestAccount registers an account for console 0402503a.
+func setupTestAccount(t *testing.T) *Account {
+	account := &Account{
+		DeviceId:   "4362227770",
+		DeviceCode: "6407877141896853",
+		Region:     "USA",
+		Country:    "US",
+		Language:   "en",
+		Status:     DeviceStatusRegistered,
+	}
+	_, err := createAccount(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// setupTestGateway serves gateway as the payment provider, returning a function restoring the previous provider.
+func setupTestGateway(gateway *mockGateway) func() {
+	server := httptest.NewServer(gateway)
+
+	previousProvider, previousPrices, previousCap := paymentProvider, pointsPrices, pointsCap
+	paymentProvider = &httpPaymentProvider{url: server.URL, client: server.Client()}
+	pointsPrices = []PointsPrice{{ItemId: "1000", Points: 1000, Amount: "10.00", Currency: "USD"}}
+	pointsCap = 0
+	return func() {
+		paymentProvider, pointsPrices, pointsCap = previousProvider, previousPrices, previousCap
+		server.Close()
+	}
+}
+
+// purchasePoints purchases points on behalf of account, returning the response's error code.
+func purchasePoints(t *testing.T, account *Account, amount string) string {
+	e := NewEnvelope("ecs", "PurchasePoints")
+	_, contents := ecsPurchasePoints(&e, &PurchasePointsRequest{
+		PointsToPurchase: 1000,
+		ItemId:           "1000",
+		Price:            MoneyRequest{Amount: amount, Currency: "USD"},
+		Payment:          PaymentRequest{PaymentMethod: "CCARD"},
+	}, account)
+
+	start := strings.Index(contents, "<ErrorCode>")
+	end := strings.Index(contents, "</ErrorCode>")
+	if start == -1 || end == -1 {
+		t.Fatalf("response has no error code: %s", contents)
+	}
+	return contents[start+len("<ErrorCode>") : end]
+}
+
+// checkLedger fails the test unless an account's balance and ledger entry types are as expected.
+func checkLedger(t *testing.T, account *Account, balance int, types ...TransactionType) {
+	got, err := store.GetBalance(account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != balance {
+		t.Errorf("balance is %d, want %d", got, balance)
+	}
+
+	entries, err := store.ListTransactions(account.AccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotTypes []TransactionType
+	for _, entry := range entries {
+		gotTypes = append(gotTypes, entry.Type)
+	}
+	if fmt.Sprint(gotTypes) != fmt.Sprint(types) {
+		t.Errorf("ledger contains %v, want %v", gotTypes, types)
+	}
+}
+
+func TestPurchasePoints(t *testing.T) {
+	defer setupTestStore(t)()
+	gateway := newMockGateway()
+	defer setupTestGateway(gateway)()
+	account := setupTestAccount(t)
+
+	if code := purchasePoints(t, account, "10.00"); code != "0" {
+		t.Fatalf("purchase failed with error code %s", code)
+	}
+
+	state, payment := gateway.state(t)
+	if state != "captured" {
+		t.Errorf("authorization is %s, want captured", state)
+	}
+	if payment.Amount != "10.00" || payment.Currency != "USD" || payment.Points != 1000 || payment.AccountId != account.AccountId {
+		t.Errorf("gateway charged %+v", payment)
+	}
+	checkLedger(t, account, 1000, TransactionPurchasePoints)
+}
+
+func TestPurchasePointsPriceMismatch(t *testing.T) {
+	defer setupTestStore(t)()
+	gateway := newMockGateway()
+	defer setupTestGateway(gateway)()
+	account := setupTestAccount(t)
+
+	// Consoles cannot choose what they are charged.
+	if code := purchasePoints(t, account, "0.01"); code != fmt.Sprint(ErrorPriceMismatch.Code) {
+		t.Errorf("purchase gave error code %s, want %d", code, ErrorPriceMismatch.Code)
+	}
+	if len(gateway.states) != 0 {
+		t.Errorf("gateway was sent %d authorizations, want none", len(gateway.states))
+	}
+	checkLedger(t, account, 0)
+}
+
+func TestPurchasePointsVoidsFailedCapture(t *testing.T) {
+	defer setupTestStore(t)()
+	gateway := newMockGateway()
+	gateway.failCapture = true
+	defer setupTestGateway(gateway)()
+	account := setupTestAccount(t)
+
+	if code := purchasePoints(t, account, "10.00"); code != fmt.Sprint(ErrorPaymentFailed.Code) {
+		t.Errorf("purchase gave error code %s, want %d", code, ErrorPaymentFailed.Code)
+	}
+	if state, _ := gateway.state(t); state != "voided" {
+		t.Errorf("authorization is %s, want voided", state)
+	}
+	checkLedger(t, account, 0)
+}
+
+func TestPurchasePointsRefundsFailedCredit(t *testing.T) {
+	defer setupTestStore(t)()
+	gateway := newMockGateway()
+	defer setupTestGateway(gateway)()
+	account := setupTestAccount(t)
+
+	// Another credit lands between checking the cap and crediting these points, so they no longer fit.
+	pointsCap = 1500
+	gateway.onCapture = func() {
+		_, err := recordTransaction(account.AccountId, TransactionRedeemECard, 1000, "")
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if code := purchasePoints(t, account, "10.00"); code != fmt.Sprint(ErrorPointsCapExceeded.Code) {
+		t.Errorf("purchase gave error code %s, want %d", code, ErrorPointsCapExceeded.Code)
+	}
+	if state, _ := gateway.state(t); state != "refunded" {
+		t.Errorf("authorization is %s, want refunded", state)
+	}
+	checkLedger(t, account, 1000, TransactionRedeemECard)
+}
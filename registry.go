@@ -0,0 +1,167 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"fmt"
+	"github.com/antchfx/xmlquery"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// AuthRequirement describes whether an action must come from a registered account.
+type AuthRequirement int
+
+const (
+	// AuthNone actions may be called by any console.
+	AuthNone AuthRequirement = iota
+	// AuthAccount actions require a registered account, but accept expired device tokens so they may be renewed.
+	AuthAccount
+	// AuthUnexpired actions require a registered account whose device token has not expired.
+	AuthUnexpired
+)
+
+func (a AuthRequirement) String() string {
+	switch a {
+	case AuthAccount:
+		return "account"
+	case AuthUnexpired:
+		return "unexpired"
+	default:
+		return "none"
+	}
+}
+
+// ActionHandler responds to a single action. request is a pointer to a decoded copy of the action's Request,
+// and account is nil unless the action requires authentication.
+type ActionHandler func(e *Envelope, request interface{}, account *Account) (bool, string)
+
+// Action describes a single SOAP action and how it is handled.
+type Action struct {
+	Service string
+	Name    string
+	// Request is a zero value of the struct requests are decoded into, such as RegisterRequest{}.
+	// Requests missing mandatory keys are rejected with ErrorInvalidRequest.
+	Request interface{}
+	Auth    AuthRequirement
+	Handler ActionHandler
+}
+
+// actions maps a service, such as ecs, to the actions registered for it by name.
+var actions = map[string]map[string]*Action{}
+
+// registerService registers the actions of a service.
+// Services register themselves within init, so registering an action twice, or an authenticated action
+// whose request lacks AccountRequest, is a programming error.
+func registerService(service string, serviceActions ...Action) {
+	if actions[service] == nil {
+		actions[service] = map[string]*Action{}
+	}
+
+	for _, action := range serviceActions {
+		if actions[service][action.Name] != nil {
+			panic("action " + service + "/" + action.Name + " registered twice")
+		}
+
+		if action.Auth != AuthNone {
+			if _, ok := action.newRequest().(accountRequester); !ok {
+				panic("action " + service + "/" + action.Name + " requires authentication without an AccountRequest")
+			}
+		}
+
+		registered := action
+		registered.Service = service
+		actions[service][action.Name] = &registered
+	}
+}
+
+// findAction returns the action registered under the given service and name, or nil if there is none.
+func findAction(service string, name string) *Action {
+	return actions[service][name]
+}
+
+// newRequest returns a pointer to a new zero value of this action's request.
+func (a *Action) newRequest() interface{} {
+	return reflect.New(reflect.TypeOf(a.Request)).Interface()
+}
+
+// handle decodes and validates a request against this action's requirements before passing it to its handler.
+func (a *Action) handle(e *Envelope, doc *xmlquery.Node) (bool, string) {
+	request := a.newRequest()
+	err := decodeRequest(doc, request)
+	if err != nil {
+		return e.ReturnError(ErrorInvalidRequest, err)
+	}
+
+	// Handlers should use the returned account rather than trusting the request.
+	var account *Account
+	switch a.Auth {
+	case AuthAccount:
+		account, err = authenticate(e, request.(accountRequester).accountRequest())
+	case AuthUnexpired:
+		account, err = authenticateUnexpired(e, request.(accountRequester).accountRequest())
+	}
+	if err != nil {
+		return e.ReturnError(authError(err), err)
+	}
+	if account != nil {
+		fmt.Println("Authenticated as account " + account.AccountId + ".")
+	}
+
+	return a.Handler(e, request, account)
+}
+
+// authError returns the error reported to consoles for a failure to authenticate.
+// Anything other than the credentials themselves being rejected, such as the database being unreachable, is our fault.
+func authError(err error) *ShopError {
+	switch err {
+	case errInvalidDeviceToken:
+		return ErrorInvalidDeviceToken
+	case errDeviceMismatch:
+		return ErrorDeviceMismatch
+	case errAccountInactive:
+		return ErrorAccountInactive
+	case errDeviceTokenExpired:
+		return ErrorDeviceTokenExpired
+	default:
+		return ErrorServiceUnavailable
+	}
+}
+
+// runActionsCommand handles the "actions" subcommand, listing every registered action.
+func runActionsCommand() {
+	var services []string
+	for service := range actions {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		var names []string
+		for name := range actions[service] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			action := actions[service][name]
+			fmt.Printf("%-4s %-28s auth: %-10s keys: %s\n", service, name, action.Auth, strings.Join(requestKeys(reflect.TypeOf(action.Request)), ", "))
+		}
+	}
+}
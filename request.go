@@ -0,0 +1,190 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"github.com/antchfx/xmlquery"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RequestError lists every problem found while decoding a request.
+type RequestError struct {
+	Problems []string
+}
+
+func (r *RequestError) Error() string {
+	return strings.Join(r.Problems, "; ")
+}
+
+// requestField describes a single key within a request struct.
+type requestField struct {
+	Key      string
+	Optional bool
+	// Nested fields are structs decoded from the children of their key, rather than its text.
+	Nested bool
+	// Index locates the field within the struct, including any embedded structs.
+	Index []int
+}
+
+// requestFields returns the keys of a request struct. Fields are named by their soap tag, such as `soap:"TitleId"`,
+// falling back to their field name, and may be marked `soap:",optional"`. Fields of embedded structs are included,
+// allowing keys shared across a service to be declared once.
+func requestFields(t reflect.Type) []requestField {
+	var fields []requestField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for _, embedded := range requestFields(field.Type) {
+				embedded.Index = append([]int{i}, embedded.Index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("soap"), ",")
+		key := tag[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		fields = append(fields, requestField{
+			Key:      key,
+			Optional: len(tag) > 1 && tag[1] == "optional",
+			Nested:   field.Type.Kind() == reflect.Struct || field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct,
+			Index:    []int{i},
+		})
+	}
+	return fields
+}
+
+// childElements returns every direct child of node with the given name.
+// Unlike an XPath search, keys nested within other elements are never matched.
+func childElements(node *xmlquery.Node, key string) []*xmlquery.Node {
+	var children []*xmlquery.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode && child.Data == key {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// decodeRequest fills the struct pointed to by request from the keys within an action's node.
+// Strings, booleans, integers, nested structs and slices of these are supported,
+// with a slice receiving every occurrence of its key.
+// All missing or malformed keys are reported together within a RequestError.
+func decodeRequest(node *xmlquery.Node, request interface{}) error {
+	requestErr := &RequestError{}
+	decodeNode(node, reflect.ValueOf(request).Elem(), "", requestErr)
+
+	if len(requestErr.Problems) > 0 {
+		return requestErr
+	}
+	return nil
+}
+
+// decodeNode fills value from the children of node, recording any problems within requestErr.
+// prefix is prepended to keys within problems, locating nested keys such as Price/Amount.
+func decodeNode(node *xmlquery.Node, value reflect.Value, prefix string, requestErr *RequestError) {
+	for _, field := range requestFields(value.Type()) {
+		key := prefix + field.Key
+		children := childElements(node, field.Key)
+		if len(children) == 0 {
+			if !field.Optional {
+				requestErr.Problems = append(requestErr.Problems, "missing mandatory key named "+key)
+			}
+			continue
+		}
+
+		target := value.FieldByIndex(field.Index)
+		if target.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(target.Type(), len(children), len(children))
+			for i, child := range children {
+				if field.Nested {
+					decodeNode(child, slice.Index(i), key+"/", requestErr)
+				} else if !setRequestValue(slice.Index(i), child.InnerText()) {
+					requestErr.Problems = append(requestErr.Problems, "invalid value for key "+key)
+					break
+				}
+			}
+			target.Set(slice)
+		} else if len(children) > 1 {
+			requestErr.Problems = append(requestErr.Problems, "key "+key+" may only be specified once")
+		} else if field.Nested {
+			decodeNode(children[0], target, key+"/", requestErr)
+		} else if !setRequestValue(target, children[0].InnerText()) {
+			requestErr.Problems = append(requestErr.Problems, "invalid value for key "+key)
+		}
+	}
+}
+
+// setRequestValue converts contents to the type of target, returning false if it could not be converted.
+func setRequestValue(target reflect.Value, contents string) bool {
+	contents = strings.TrimSpace(contents)
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(contents)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(contents)
+		if err != nil {
+			return false
+		}
+		target.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(contents, 10, target.Type().Bits())
+		if err != nil {
+			return false
+		}
+		target.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(contents, 10, target.Type().Bits())
+		if err != nil {
+			return false
+		}
+		target.SetUint(parsed)
+	default:
+		panic("unsupported request field type " + target.Type().String())
+	}
+	return true
+}
+
+// requestKeys describes the keys of a request struct, such as for listing actions.
+// Optional keys are suffixed with ?, and nested keys are listed within their parent.
+func requestKeys(t reflect.Type) []string {
+	var keys []string
+	for _, field := range requestFields(t) {
+		key := field.Key
+		if field.Nested {
+			nested := t.FieldByIndex(field.Index).Type
+			if nested.Kind() == reflect.Slice {
+				nested = nested.Elem()
+			}
+			key += "(" + strings.Join(requestKeys(nested), ", ") + ")"
+		}
+		if field.Optional {
+			key += "?"
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,176 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRequestPrice struct {
+	Amount   string `soap:"Amount"`
+	Currency string `soap:"Currency,optional"`
+}
+
+type testRequest struct {
+	Name   string             `soap:"Name"`
+	Count  int                `soap:"Count"`
+	Small  uint8              `soap:"Small,optional"`
+	Flag   bool               `soap:"Flag,optional"`
+	Ids    []string           `soap:"Id,optional"`
+	Price  testRequestPrice   `soap:"Price,optional"`
+	Limits []testRequestPrice `soap:"Limit,optional"`
+}
+
+// decodeTestRequest decodes a testRequest from the given keys, as if sent by a console.
+func decodeTestRequest(t *testing.T, keys string) (*testRequest, error) {
+	body := `<ecs:Test xmlns:ecs="urn:ecs.wsapi.broadon.com">` + strings.Replace(keys, "<", "<ecs:", -1) + `</ecs:Test>`
+	body = strings.Replace(body, "<ecs:/", "</ecs:", -1)
+	doc, err := normalise("ecs", "Test", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &testRequest{}
+	return request, decodeRequest(doc, request)
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		expected testRequest
+	}{
+		{
+			name:     "mandatory keys",
+			keys:     `<Name>Mii</Name><Count>-3</Count>`,
+			expected: testRequest{Name: "Mii", Count: -3},
+		},
+		{
+			name:     "surrounding whitespace",
+			keys:     `<Name> Mii </Name><Count> 3 </Count><Flag>true</Flag><Small>255</Small>`,
+			expected: testRequest{Name: "Mii", Count: 3, Flag: true, Small: 255},
+		},
+		{
+			name:     "repeated keys",
+			keys:     `<Name>Mii</Name><Count>1</Count><Id>1</Id><Id>2</Id><Id>3</Id>`,
+			expected: testRequest{Name: "Mii", Count: 1, Ids: []string{"1", "2", "3"}},
+		},
+		{
+			name:     "nested keys",
+			keys:     `<Name>Mii</Name><Count>1</Count><Price><Amount>500</Amount><Currency>POINTS</Currency></Price>`,
+			expected: testRequest{Name: "Mii", Count: 1, Price: testRequestPrice{Amount: "500", Currency: "POINTS"}},
+		},
+		{
+			name: "repeated nested keys",
+			keys: `<Name>Mii</Name><Count>1</Count><Limit><Amount>1</Amount></Limit><Limit><Amount>2</Amount></Limit>`,
+			expected: testRequest{Name: "Mii", Count: 1, Limits: []testRequestPrice{
+				{Amount: "1"},
+				{Amount: "2"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		request, err := decodeTestRequest(t, test.keys)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*request, test.expected) {
+			t.Errorf("%s: decoded %+v, want %+v", test.name, *request, test.expected)
+		}
+	}
+}
+
+func TestDecodeRequestProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		problems []string
+	}{
+		{
+			name:     "missing keys are reported together",
+			keys:     ``,
+			problems: []string{"missing mandatory key named Name", "missing mandatory key named Count"},
+		},
+		{
+			name:     "keys nested elsewhere are not matched",
+			keys:     `<Count>1</Count><Price><Name>Mii</Name><Amount>1</Amount></Price>`,
+			problems: []string{"missing mandatory key named Name"},
+		},
+		{
+			name:     "malformed numbers",
+			keys:     `<Name>Mii</Name><Count>three</Count>`,
+			problems: []string{"invalid value for key Count"},
+		},
+		{
+			name:     "numbers out of range",
+			keys:     `<Name>Mii</Name><Count>1</Count><Small>256</Small>`,
+			problems: []string{"invalid value for key Small"},
+		},
+		{
+			name:     "negative unsigned numbers",
+			keys:     `<Name>Mii</Name><Count>1</Count><Small>-1</Small>`,
+			problems: []string{"invalid value for key Small"},
+		},
+		{
+			name:     "malformed booleans",
+			keys:     `<Name>Mii</Name><Count>1</Count><Flag>maybe</Flag>`,
+			problems: []string{"invalid value for key Flag"},
+		},
+		{
+			name:     "repeated single keys",
+			keys:     `<Name>Mii</Name><Name>Mii</Name><Count>1</Count>`,
+			problems: []string{"key Name may only be specified once"},
+		},
+		{
+			name:     "missing nested keys",
+			keys:     `<Name>Mii</Name><Count>1</Count><Price><Currency>POINTS</Currency></Price>`,
+			problems: []string{"missing mandatory key named Price/Amount"},
+		},
+		{
+			name:     "missing keys within repeated nested keys",
+			keys:     `<Name>Mii</Name><Count>1</Count><Limit><Amount>1</Amount></Limit><Limit></Limit>`,
+			problems: []string{"missing mandatory key named Limit/Amount"},
+		},
+		{
+			name: "all problems are reported together",
+			keys: `<Count>x</Count><Small>999</Small><Price></Price>`,
+			problems: []string{
+				"missing mandatory key named Name",
+				"invalid value for key Count",
+				"invalid value for key Small",
+				"missing mandatory key named Price/Amount",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := decodeTestRequest(t, test.keys)
+		requestErr, ok := err.(*RequestError)
+		if !ok {
+			t.Errorf("%s: returned %v, want a RequestError", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(requestErr.Problems, test.problems) {
+			t.Errorf("%s: reported %q, want %q", test.name, requestErr.Problems, test.problems)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+)
+
+// MarshalXML encodes the common fields of a response, followed by the fields of its payload.
+// The Wii Shop Channel expects action-specific keys alongside common keys, so payloads are flattened
+// into the response rather than nested within their own element.
+func (r Response) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start.Name = r.XMLName
+	start.Attr = []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: r.XMLNS}}
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	err = encodeFields(enc, reflect.ValueOf(r))
+	if err != nil {
+		return err
+	}
+	if r.Payload != nil {
+		err = encodeFields(enc, reflect.ValueOf(r.Payload))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// encodeFields encodes every field of a struct as an element, in the order they are declared.
+// Elements are named by their xml tag, falling back to their field name, and slices are encoded once per item.
+// XMLName, attribute and `xml:"-"` fields are skipped, and `xml:",omitempty"` is honoured.
+func encodeFields(enc *xml.Encoder, value reflect.Value) error {
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := strings.Split(field.Tag.Get("xml"), ",")
+		name := tag[0]
+		if name == "-" || field.Name == "XMLName" || hasOption(tag[1:], "attr") {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldValue := value.Field(i)
+		if hasOption(tag[1:], "omitempty") && isEmptyValue(fieldValue) {
+			continue
+		}
+
+		err := enc.EncodeElement(fieldValue.Interface(), xml.StartElement{Name: xml.Name{Local: name}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOption returns whether an xml tag's options contain the given option.
+func hasOption(options []string, option string) bool {
+	for _, candidate := range options {
+		if candidate == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue mirrors what encoding/xml considers empty for omitempty.
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint() == 0
+	case reflect.Interface, reflect.Ptr:
+		return value.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// goldenTimeStamp replaces the time of each response, so they may be compared with those within testdata/golden.
+const goldenTimeStamp = "1600000000000"
+
+// The responses within testdata/golden are written by hand in the layout the Wii Shop Channel receives,
+// rather than produced by encoding these payloads. They are compared structurally, ignoring indentation,
+// so captured traffic may replace them as-is once its payload here is updated to match.
+var goldenPayloads = map[string]interface{}{
+	"cas/ListTitles": ListTitlesResponse{
+		ListResultTotalSize: 1,
+		TitleInfos:          []TitleInfo{goldenTitleInfo},
+	},
+	"cas/ListContentSets": ListContentSetsResponse{
+		ListResultTotalSize: 1,
+		ContentSets: []ContentSets{
+			{TitleId: "0001000146414245", Version: 2, FsSize: 1048576},
+		},
+	},
+	"cas/GetTitleDetails": GetTitleDetailsResponse{
+		TitleInfo: goldenTitleInfo,
+	},
+	"ecs/CheckDeviceStatus": CheckDeviceStatusResponse{
+		Balance:       Balance{Amount: 1000, Currency: "POINTS"},
+		ForceSyncTime: 0,
+		ExtTicketTime: goldenTimeStamp,
+		SyncTime:      goldenTimeStamp,
+	},
+	"ecs/CheckAccountBalance": CheckAccountBalanceResponse{
+		Balance:    Balance{Amount: 1000, Currency: "POINTS"},
+		MaxBalance: 10000,
+	},
+	"ecs/NotifyETicketsSynced": NotifyETicketsSyncedResponse{},
+	"ecs/ListETickets": ListETicketsResponse{
+		ForceSyncTime: 0,
+		ExtTicketTime: goldenTimeStamp,
+		SyncTime:      goldenTimeStamp,
+		Tickets: []Tickets{
+			{TicketId: "81985529216486895", TitleId: "0001000146414245", Version: 513},
+			{TicketId: "1311768467463790320", TitleId: "0001000146414345", RevokeDate: 1600000000001},
+		},
+	},
+	"ecs/GetETickets": GetETicketsResponse{
+		ForceSyncTime: 0,
+		ETickets:      []string{"VElDS0VU"},
+		Certs:         goldenCerts,
+	},
+	"ecs/PurchaseTitle": PurchaseTitleResponse{
+		Balance:      Balance{Amount: 500, Currency: "POINTS"},
+		Transactions: Transactions{TransactionId: "1234567890", Date: goldenTimeStamp, Type: "PURCHGAME"},
+		SyncTime:     goldenTimeStamp,
+		Certs:        goldenCerts,
+		TitleId:      "0001000146414245",
+		ETickets:     "VElDS0VU",
+	},
+	"ecs/PurchasePoints": PurchasePointsResponse{
+		Balance:      Balance{Amount: 2000, Currency: "POINTS"},
+		Transactions: Transactions{TransactionId: "1234567890", Date: goldenTimeStamp, Type: "PURCHPOINTS"},
+	},
+	"ecs/CheckECardBalance": CheckECardBalanceResponse{
+		Balance: Balance{Amount: 1000, Currency: "POINTS"},
+	},
+	"ecs/RedeemECard": RedeemECardResponse{
+		Balance:      Balance{Amount: 1000, Currency: "POINTS"},
+		Transactions: Transactions{TransactionId: "1234567890", Date: goldenTimeStamp, Type: "REDEEMECARD"},
+	},
+	"ecs/ListPurchaseHistory": ListPurchaseHistoryResponse{
+		ListResultTotalSize: 2,
+		Transactions: []Transactions{
+			{TransactionId: "1234567891", Date: "1600000000001", Type: "PURCHGAME", TitleId: "0001000146414245", Points: -500},
+			{TransactionId: "1234567890", Date: goldenTimeStamp, Type: "REDEEMECARD", Points: 1000},
+		},
+	},
+	"ecs/ListGifts": ListGiftsResponse{
+		Gifts: []GiftInfo{
+			{GiftId: "1234567890", TitleId: "0001000146414245", SenderDeviceCode: "6407877141896853", Message: "Enjoy!", Date: 1600000000000},
+		},
+	},
+	"ecs/AcceptGift": AcceptGiftResponse{
+		SyncTime: goldenTimeStamp,
+		Certs:    goldenCerts,
+		TitleId:  "0001000146414245",
+		ETickets: "VElDS0VU",
+	},
+	"ecs/DeclineGift": DeclineGiftResponse{},
+	"ias/CheckRegistration": CheckRegistrationResponse{
+		OriginalSerialNumber: "LU123456789",
+		DeviceStatus:         DeviceStatusRegistered,
+	},
+	"ias/GetChallenge": GetChallengeResponse{
+		Challenge: SharedChallenge,
+	},
+	"ias/GetRegistrationInfo": GetRegistrationInfoResponse{
+		AccountId:    "123456789",
+		DeviceToken:  "1234567890abcdefghijk",
+		Country:      "US",
+		DeviceCode:   "6407877141896853",
+		DeviceStatus: DeviceStatusRegistered,
+		Currency:     "POINTS",
+	},
+	"ias/Register": RegisterResponse{
+		AccountId:   "123456789",
+		DeviceToken: "1234567890abcdefghijk",
+		Country:     "US",
+		DeviceCode:  "6407877141896853",
+	},
+	"ias/SyncRegistration": SyncRegistrationResponse{
+		AccountId:    "123456789",
+		DeviceToken:  "1234567890abcdefghijk",
+		Country:      "US",
+		DeviceCode:   "6407877141896853",
+		DeviceStatus: DeviceStatusRegistered,
+		Currency:     "POINTS",
+	},
+	"ias/Unregister": UnregisterResponse{},
+	"nus/GetSystemUpdate": GetSystemUpdateResponse{
+		ContentPrefixURL:         "http://nus.example.com/ccs/download",
+		UncachedContentPrefixURL: "http://nus.example.com/ccs/download",
+		TitleVersions: []TitleVersion{
+			{TitleId: "0000000100000002", Version: 513, FsSize: 1048576},
+		},
+		UploadAuditData: 1,
+	},
+	"nus/GetSystemTitleHash": GetSystemTitleHashResponse{
+		TitleHash: "0123456789ABCDEF0123456789ABCDEF",
+	},
+	"nus/GetSystemCommonETicket": GetSystemCommonETicketResponse{
+		CommonETickets: []string{"VElDS0VU"},
+		Certs:          goldenCerts,
+	},
+}
+
+var (
+	goldenCerts     = []string{"WFMwMDAwMDAwMw==", "Q0EwMDAwMDAwMQ=="}
+	goldenTitleInfo = TitleInfo{
+		TitleId:     "0001000146414245",
+		TitleName:   "Mii Maker",
+		Platform:    "WII",
+		Version:     2,
+		ReleaseDate: 1600000000000,
+		TitleSize:   1048576,
+		Price:       Price{Amount: 500, Currency: "POINTS"},
+		Ratings:     []Rating{{Name: "ESRB", Value: "E"}},
+	}
+)
+
+// goldenEnvelope returns an envelope for the given action, with the common fields every golden response shares.
+func goldenEnvelope(service string, action string) Envelope {
+	e := NewEnvelope(service, action)
+	e.Body.Response.Version = "2.0"
+	e.Body.Response.DeviceId = "4362227770"
+	e.Body.Response.MessageId = "ECommerceSOAP"
+	e.Body.Response.TimeStamp = goldenTimeStamp
+	return e
+}
+
+// xmlTokens describes the tokens within a document, ignoring whitespace between elements.
+func xmlTokens(t *testing.T, contents string) []string {
+	var tokens []string
+	decoder := xml.NewDecoder(strings.NewReader(contents))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return tokens
+		} else if err != nil {
+			t.Fatalf("%v within %s", err, contents)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			var attrs []string
+			for _, attr := range token.Attr {
+				attrs = append(attrs, fmt.Sprintf("%s:%s=%q", attr.Name.Space, attr.Name.Local, attr.Value))
+			}
+			sort.Strings(attrs)
+			tokens = append(tokens, fmt.Sprintf("<%s:%s %s>", token.Name.Space, token.Name.Local, strings.Join(attrs, " ")))
+		case xml.EndElement:
+			tokens = append(tokens, fmt.Sprintf("</%s:%s>", token.Name.Space, token.Name.Local))
+		case xml.CharData:
+			if text := strings.TrimSpace(string(token)); text != "" {
+				tokens = append(tokens, text)
+			}
+		case xml.ProcInst:
+			tokens = append(tokens, fmt.Sprintf("<?%s %s?>", token.Target, token.Inst))
+		}
+	}
+}
+
+// checkGolden fails the test unless contents matches the golden response with the given name.
+func checkGolden(t *testing.T, name string, contents string) {
+	expected, err := ioutil.ReadFile("testdata/golden/" + name + ".xml")
+	if err != nil {
+		t.Errorf("%s has no golden response: %v", name, err)
+		return
+	}
+
+	got, want := xmlTokens(t, contents), xmlTokens(t, string(expected))
+	for i := 0; i < len(got) || i < len(want); i++ {
+		if i >= len(got) || i >= len(want) || got[i] != want[i] {
+			t.Errorf("%s does not match its golden response:\ngot  %s\nwant %s", name, contents, expected)
+			return
+		}
+	}
+}
+
+func TestResponseGolden(t *testing.T) {
+	// Every registered action must be covered.
+	for service := range actions {
+		for name := range actions[service] {
+			if _, ok := goldenPayloads[service+"/"+name]; !ok {
+				t.Errorf("%s/%s has no golden payload", service, name)
+			}
+		}
+	}
+
+	for key, payload := range goldenPayloads {
+		service, action := parseGoldenKey(key)
+		if findAction(service, action) == nil {
+			t.Errorf("%s is not a registered action", key)
+			continue
+		}
+
+		e := goldenEnvelope(service, action)
+		ok, contents := e.ReturnSuccess(payload)
+		if !ok {
+			t.Errorf("%s could not be encoded: %s", key, contents)
+			continue
+		}
+		checkGolden(t, action, contents)
+	}
+}
+
+// parseGoldenKey splits a key of goldenPayloads, such as "ecs/PurchaseTitle", into its service and action.
+func parseGoldenKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
+}
+
+func TestErrorResponseGolden(t *testing.T) {
+	e := goldenEnvelope("ecs", "PurchaseTitle")
+	ok, contents := e.ReturnError(ErrorInsufficientBalance, errInsufficientBalance)
+	if !ok {
+		t.Fatalf("error could not be encoded: %s", contents)
+	}
+	checkGolden(t, "Error", contents)
+}
+
+func TestFaultGolden(t *testing.T) {
+	w := httptest.NewRecorder()
+	printError(w, 500, FaultCodeClient, "Unsupported service type...", "xyz")
+	if w.Code != 500 {
+		t.Errorf("fault was sent with status %d, want 500", w.Code)
+	}
+	checkGolden(t, "Fault", w.Body.String())
+}
+
+func TestCheckGoldenIgnoresIndentation(t *testing.T) {
+	contents := "<a><b>1</b><c></c></a>"
+	if fmt.Sprint(xmlTokens(t, contents)) != fmt.Sprint(xmlTokens(t, "<a>\n  <b>1</b>\n  <c/>\n</a>\n")) {
+		t.Error("indentation changed the tokens of a document")
+	}
+	if fmt.Sprint(xmlTokens(t, contents)) == fmt.Sprint(xmlTokens(t, "<a><c></c><b>1</b></a>")) {
+		t.Error("element order did not change the tokens of a document")
+	}
+}
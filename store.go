@@ -0,0 +1,118 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"errors"
+)
+
+var errAccountExists = errors.New("user already exists")
+
+// Store describes everything WiiSOAP persists.
+// Lookups which find nothing return nil rather than an error.
+type Store interface {
+	// CreateAccount registers a new account, returning errAccountExists if it conflicts with an existing one.
+	CreateAccount(account Account, deviceTokenHash string) error
+	// GetAccount returns the account matching the given account ID and device token hash.
+	GetAccount(accountId string, deviceTokenHash string) (*Account, error)
+	// GetAccountByDevice returns the account registered to a device ID, regardless of its status.
+	GetAccountByDevice(deviceId string) (*Account, error)
+	// GetAccountByDeviceCode returns the registered account currently using a device code, or nil should there be none.
+	GetAccountByDeviceCode(deviceCode string) (*Account, error)
+	// UnregisterAccount marks an account as unregistered, replacing its device token hash with the given one.
+	UnregisterAccount(accountId string, revokedTokenHash string) error
+	// ReactivateAccount registers an account again with updated details and a new device token hash.
+	// Banned accounts are never reactivated.
+	ReactivateAccount(account Account, deviceTokenHash string) error
+	// UpdateDeviceToken replaces an account's device token hash, alongside its issue and expiry times.
+	UpdateDeviceToken(account Account, deviceTokenHash string) error
+	// RevokeDeviceTokens replaces an account's device token hash with the given one, marking it as unregistered
+	// should it be registered. It returns errUnknownAccount if the account does not exist.
+	RevokeDeviceTokens(accountId string, revokedTokenHash string) error
+
+	// GetTitleKey returns the decrypted title key for a title.
+	GetTitleKey(titleId uint64) (*[16]byte, error)
+	// PurchaseTitle records a ticket as belonging to the account debited by entry, alongside the entry itself.
+	// Neither is recorded should the other fail, with titles the account already owns rejected with errTitleAlreadyOwned.
+	PurchaseTitle(owned OwnedTitle, entry LedgerEntry) error
+	// GetOwnedTitle returns the ticket an account holds for a title.
+	GetOwnedTitle(accountId string, titleId uint64) (*OwnedTitle, error)
+	// ListOwnedTitles returns all tickets held by an account.
+	ListOwnedTitles(accountId string) ([]OwnedTitle, error)
+	// IsTitleOwned returns whether any account has been issued a ticket for a title.
+	IsTitleOwned(titleId uint64) (bool, error)
+
+	// GetBalance returns the current points balance for an account.
+	GetBalance(accountId string) (int, error)
+	// RecordTransaction applies a ledger entry to its account's balance and records it.
+	// Debits which would leave the account with a negative balance are rejected with errInsufficientBalance,
+	// credits beyond the entry's creditLimit with errPointsCapExceeded, and entries for unknown accounts with errUnknownAccount.
+	RecordTransaction(entry LedgerEntry) error
+	// ListTransactions returns all ledger entries for an account, most recent first.
+	// Entries made within the same millisecond are ordered by their transaction ID, so pages remain stable.
+	ListTransactions(accountId string) ([]LedgerEntry, error)
+
+	// AddECards stores newly minted cards, failing without storing any should one already exist.
+	AddECards(cards []ECard) error
+	// GetECard returns the card with the given code hash.
+	GetECard(codeHash string) (*ECard, error)
+	// RedeemECard marks a card as redeemed by the entry's account, recording the entry alongside it.
+	// Cards which have already been redeemed are rejected with errECardRedeemed.
+	RedeemECard(codeHash string, entry LedgerEntry) error
+
+	// SendGift records a pending gift, alongside the entry debiting its sender.
+	SendGift(gift Gift, entry LedgerEntry) error
+	// GetGift returns the gift with the given ID.
+	GetGift(giftId string) (*Gift, error)
+	// ListPendingGifts returns all gifts awaiting an account, oldest first.
+	ListPendingGifts(recipientAccountId string) ([]Gift, error)
+	// AcceptGift marks a pending gift as accepted, adding the given title to its recipient's account.
+	// Gifts which are no longer pending are rejected with errGiftResolved,
+	// and titles the recipient already owns with errTitleAlreadyOwned.
+	AcceptGift(gift Gift, owned OwnedTitle) error
+	// DeclineGift marks a pending gift as declined, recording the entry refunding its sender.
+	// Gifts which are no longer pending are rejected with errGiftResolved.
+	DeclineGift(gift Gift, refund LedgerEntry) error
+
+	// GetCatalogTitle returns a title within the catalog.
+	GetCatalogTitle(titleId uint64) (*CatalogTitle, error)
+	// ListCatalogTitles returns all titles within a region, optionally limited to a platform.
+	ListCatalogTitles(region string, platform string) ([]CatalogTitle, error)
+
+	// SchemaVersion returns the version of the most recently applied migration.
+	SchemaVersion() (int, error)
+	// Migrate applies or reverts migrations until the schema is at the target version.
+	Migrate(target int) error
+
+	Close() error
+}
+
+// store is the Store used by all handlers.
+var store Store
+
+// openStore opens the database backend selected within the config.
+func openStore(config Config) (Store, error) {
+	switch config.Database {
+	case "", "mysql":
+		return newMySQLStore(config)
+	case "sqlite":
+		return newSQLiteStore(config)
+	default:
+		return nil, errors.New("unknown database type " + config.Database)
+	}
+}
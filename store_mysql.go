@@ -0,0 +1,49 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+)
+
+// newMySQLStore connects to the MySQL server within the config.
+func newMySQLStore(config Config) (Store, error) {
+	// Updates which match a row without changing it, such as crediting 0 points, must still count as affecting it.
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/%s?clientFoundRows=true", config.SQLUser, config.SQLPass, config.SQLAddress, config.SQLDB))
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqlStore{
+		db:          db,
+		isDuplicate: isMySQLDuplicate,
+	}, nil
+}
+
+// isMySQLDuplicate returns whether an error is MySQL's ER_DUP_ENTRY.
+func isMySQLDuplicate(err error) bool {
+	driverErr, ok := err.(*mysql.MySQLError)
+	return ok && driverErr.Number == 1062
+}
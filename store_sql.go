@@ -0,0 +1,672 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// sqlStore implements Store for any database/sql driver, as MySQL and SQLite share the same queries.
+type sqlStore struct {
+	db *sql.DB
+
+	// isDuplicate returns whether an error was caused by violating a unique key.
+	isDuplicate func(err error) bool
+	// sqlite is whether the database is SQLite rather than MySQL, as some schema changes differ between the two.
+	sqlite bool
+}
+
+// query runs the given query, returning its rows.
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return nil, errors.New("failed to execute db operation")
+	}
+	return rows, nil
+}
+
+// queryRow runs the given query, scanning its only row into dest. It returns false if there was no row.
+func (s *sqlStore) queryRow(query string, args []interface{}, dest ...interface{}) (bool, error) {
+	err := s.db.QueryRow(query, args...).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return false, errors.New("failed to execute db operation")
+	}
+	return true, nil
+}
+
+// queryRowTx is queryRow within a transaction.
+func queryRowTx(tx *sql.Tx, query string, args []interface{}, dest ...interface{}) (bool, error) {
+	err := tx.QueryRow(query, args...).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return false, errors.New("failed to execute db operation")
+	}
+	return true, nil
+}
+
+func (s *sqlStore) CreateAccount(account Account, deviceTokenHash string) error {
+	_, err := s.db.Exec(`INSERT INTO userbase (DeviceId, DeviceToken, AccountId, Region, Country, Language, SerialNo, DeviceCode, ExtAccountId, DeviceTokenIssued, DeviceTokenExpiry, DevicePublicKey) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		account.DeviceId, deviceTokenHash, account.AccountId, account.Region, account.Country, account.Language, account.SerialNo, account.DeviceCode, account.ExtAccountId,
+		account.DeviceTokenIssued, account.DeviceTokenExpiry, hex.EncodeToString(account.DevicePublicKey))
+	if err != nil {
+		// It's okay if this isn't a duplicate, as perhaps other issues have come in.
+		if s.isDuplicate(err) {
+			return errAccountExists
+		}
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetAccount(accountId string, deviceTokenHash string) (*Account, error) {
+	return s.queryAccount(`SELECT AccountId, DeviceId, DeviceCode, Region, Country, Language, SerialNo, Status, ExtAccountId, DeviceTokenIssued, DeviceTokenExpiry, DevicePublicKey FROM userbase WHERE AccountId = ? AND DeviceToken = ?`,
+		accountId, deviceTokenHash)
+}
+
+func (s *sqlStore) GetAccountByDevice(deviceId string) (*Account, error) {
+	return s.queryAccount(`SELECT AccountId, DeviceId, DeviceCode, Region, Country, Language, SerialNo, Status, ExtAccountId, DeviceTokenIssued, DeviceTokenExpiry, DevicePublicKey FROM userbase WHERE DeviceId = ?`,
+		deviceId)
+}
+
+func (s *sqlStore) GetAccountByDeviceCode(deviceCode string) (*Account, error) {
+	// A console's device ID is fixed in hardware, but formatting it issues a new device code, replacing the old one
+	// once it registers again. Unregistered accounts may still hold a code their console no longer uses.
+	return s.queryAccount(`SELECT AccountId, DeviceId, DeviceCode, Region, Country, Language, SerialNo, Status, ExtAccountId, DeviceTokenIssued, DeviceTokenExpiry, DevicePublicKey FROM userbase WHERE DeviceCode = ? AND Status = ?`,
+		deviceCode, DeviceStatusRegistered)
+}
+
+// queryAccount runs the given query, interpreting its only row as an account.
+func (s *sqlStore) queryAccount(query string, args ...interface{}) (*Account, error) {
+	account := Account{}
+	var publicKey string
+	found, err := s.queryRow(query, args,
+		&account.AccountId, &account.DeviceId, &account.DeviceCode, &account.Region, &account.Country, &account.Language, &account.SerialNo, &account.Status,
+		&account.ExtAccountId, &account.DeviceTokenIssued, &account.DeviceTokenExpiry, &publicKey)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	account.DevicePublicKey, err = hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.New("stored device public key is malformed")
+	}
+
+	return &account, nil
+}
+
+func (s *sqlStore) UnregisterAccount(accountId string, revokedTokenHash string) error {
+	_, err := s.db.Exec(`UPDATE userbase SET Status = ?, DeviceToken = ? WHERE AccountId = ?`, DeviceStatusUnregistered, revokedTokenHash, accountId)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) ReactivateAccount(account Account, deviceTokenHash string) error {
+	_, err := s.db.Exec(`UPDATE userbase SET Status = ?, DeviceToken = ?, Region = ?, Country = ?, Language = ?, SerialNo = ?, DeviceCode = ?, DeviceTokenIssued = ?, DeviceTokenExpiry = ?, DevicePublicKey = ? WHERE AccountId = ? AND Status != ?`,
+		DeviceStatusRegistered, deviceTokenHash, account.Region, account.Country, account.Language, account.SerialNo, account.DeviceCode,
+		account.DeviceTokenIssued, account.DeviceTokenExpiry, hex.EncodeToString(account.DevicePublicKey), account.AccountId, DeviceStatusBanned)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpdateDeviceToken(account Account, deviceTokenHash string) error {
+	_, err := s.db.Exec(`UPDATE userbase SET DeviceToken = ?, DeviceTokenIssued = ?, DeviceTokenExpiry = ? WHERE AccountId = ?`,
+		deviceTokenHash, account.DeviceTokenIssued, account.DeviceTokenExpiry, account.AccountId)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) RevokeDeviceTokens(accountId string, revokedTokenHash string) error {
+	// Banned accounts must stay banned.
+	result, err := s.db.Exec(`UPDATE userbase SET DeviceToken = ?, Status = CASE WHEN Status = ? THEN ? ELSE Status END WHERE AccountId = ?`,
+		revokedTokenHash, DeviceStatusRegistered, DeviceStatusUnregistered, accountId)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	if affected == 0 {
+		return errUnknownAccount
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetTitleKey(titleId uint64) (*[16]byte, error) {
+	var encoded string
+	found, err := s.queryRow(`SELECT TitleKey FROM titlekeys WHERE TitleId = ?`, []interface{}{fmt.Sprintf("%016x", titleId)}, &encoded)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	var titleKey [16]byte
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || len(decoded) != len(titleKey) {
+		return nil, errors.New("stored title key is malformed")
+	}
+	copy(titleKey[:], decoded)
+
+	return &titleKey, nil
+}
+
+func (s *sqlStore) PurchaseTitle(owned OwnedTitle, entry LedgerEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	err = applyTransaction(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO owned_titles (TicketId, AccountId, TitleId, Version, RevokeDate, PurchaseDate, TransactionId) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		fmt.Sprint(owned.TicketId), entry.AccountId, fmt.Sprintf("%016x", owned.TitleId), owned.Version, owned.RevokeDate, owned.PurchaseDate, owned.TransactionId)
+	if err != nil {
+		// Concurrent purchases of the same title may have raced one another.
+		if s.isDuplicate(err) {
+			return errTitleAlreadyOwned
+		}
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetOwnedTitle(accountId string, titleId uint64) (*OwnedTitle, error) {
+	owned, err := s.queryOwnedTitles(`SELECT TicketId, TitleId, Version, RevokeDate, PurchaseDate, TransactionId FROM owned_titles WHERE AccountId = ? AND TitleId = ?`,
+		accountId, fmt.Sprintf("%016x", titleId))
+	if err != nil || len(owned) == 0 {
+		return nil, err
+	}
+
+	return &owned[0], nil
+}
+
+func (s *sqlStore) ListOwnedTitles(accountId string) ([]OwnedTitle, error) {
+	return s.queryOwnedTitles(`SELECT TicketId, TitleId, Version, RevokeDate, PurchaseDate, TransactionId FROM owned_titles WHERE AccountId = ? ORDER BY PurchaseDate`, accountId)
+}
+
+func (s *sqlStore) IsTitleOwned(titleId uint64) (bool, error) {
+	var count int
+	_, err := s.queryRow(`SELECT COUNT(*) FROM owned_titles WHERE TitleId = ?`, []interface{}{fmt.Sprintf("%016x", titleId)}, &count)
+	return count > 0, err
+}
+
+// queryOwnedTitles runs the given query, interpreting all rows as owned titles.
+func (s *sqlStore) queryOwnedTitles(query string, args ...interface{}) ([]OwnedTitle, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owned []OwnedTitle
+	for rows.Next() {
+		var title OwnedTitle
+		var ticketId, titleId string
+		err := rows.Scan(&ticketId, &titleId, &title.Version, &title.RevokeDate, &title.PurchaseDate, &title.TransactionId)
+		if err != nil {
+			log.Printf("error scanning row: %v\n", err)
+			return nil, errors.New("failed to execute db operation")
+		}
+
+		title.TicketId, err = strconv.ParseUint(ticketId, 10, 64)
+		if err != nil {
+			return nil, errors.New("stored ticket ID is malformed")
+		}
+		title.TitleId, err = parseTitleId(titleId)
+		if err != nil {
+			return nil, errors.New("stored title ID is malformed")
+		}
+
+		owned = append(owned, title)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("error iterating rows: %v\n", err)
+		return nil, errors.New("failed to execute db operation")
+	}
+	return owned, nil
+}
+
+func (s *sqlStore) GetBalance(accountId string) (int, error) {
+	var balance int
+	found, err := s.queryRow(`SELECT Points FROM userbase WHERE AccountId = ?`, []interface{}{accountId}, &balance)
+	if err == nil && !found {
+		err = errors.New("account does not exist")
+	}
+	return balance, err
+}
+
+func (s *sqlStore) RecordTransaction(entry LedgerEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	err = applyTransaction(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) ListTransactions(accountId string) ([]LedgerEntry, error) {
+	rows, err := s.query(`SELECT TransactionId, Type, Amount, TitleId, Date FROM ledger WHERE AccountId = ? ORDER BY Date DESC, TransactionId DESC`, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		entry := LedgerEntry{AccountId: accountId}
+		var transactionType string
+		err := rows.Scan(&entry.TransactionId, &transactionType, &entry.Amount, &entry.TitleId, &entry.Date)
+		if err != nil {
+			log.Printf("error scanning row: %v\n", err)
+			return nil, errors.New("failed to execute db operation")
+		}
+		entry.Type = TransactionType(transactionType)
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("error iterating rows: %v\n", err)
+		return nil, errors.New("failed to execute db operation")
+	}
+	return entries, nil
+}
+
+// applyTransaction adjusts the balance of an entry's account and records the entry within tx.
+func applyTransaction(tx *sql.Tx, entry LedgerEntry) error {
+	// Checking the balance within the update itself avoids racing concurrent purchases.
+	limit := creditLimit(entry)
+	result, err := tx.Exec(`UPDATE userbase SET Points = Points + ? WHERE AccountId = ? AND Points + ? >= 0 AND (? = 0 OR Points + ? <= ?)`,
+		entry.Amount, entry.AccountId, entry.Amount, limit, entry.Amount, limit)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	if affected == 0 {
+		// Nothing matched, either because the account does not exist or because the balance check failed.
+		var balance int
+		found, err := queryRowTx(tx, `SELECT Points FROM userbase WHERE AccountId = ?`, []interface{}{entry.AccountId}, &balance)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errUnknownAccount
+		}
+		if entry.Amount > 0 {
+			return errPointsCapExceeded
+		}
+		return errInsufficientBalance
+	}
+
+	_, err = tx.Exec(`INSERT INTO ledger (TransactionId, AccountId, Type, Amount, TitleId, Date) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.TransactionId, entry.AccountId, string(entry.Type), entry.Amount, entry.TitleId, entry.Date)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) AddECards(cards []ECard) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	for _, card := range cards {
+		_, err = tx.Exec(`INSERT INTO ecards (CodeHash, Value, Currency, Expiry) VALUES (?, ?, ?, ?)`,
+			card.CodeHash, card.Value, card.Currency, card.Expiry)
+		if err != nil {
+			log.Printf("error executing statement: %v\n", err)
+			return errors.New("failed to execute db operation")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetECard(codeHash string) (*ECard, error) {
+	card := ECard{}
+	found, err := s.queryRow(`SELECT CodeHash, Value, Currency, Expiry, RedeemedBy, RedeemedAt FROM ecards WHERE CodeHash = ?`, []interface{}{codeHash},
+		&card.CodeHash, &card.Value, &card.Currency, &card.Expiry, &card.RedeemedBy, &card.RedeemedAt)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+func (s *sqlStore) RedeemECard(codeHash string, entry LedgerEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	// Only claiming unredeemed cards within the update avoids two consoles racing to redeem the same card.
+	result, err := tx.Exec(`UPDATE ecards SET RedeemedBy = ?, RedeemedAt = ? WHERE CodeHash = ? AND RedeemedBy = ''`, entry.AccountId, entry.Date, codeHash)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	if affected == 0 {
+		return errECardRedeemed
+	}
+
+	err = applyTransaction(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) SendGift(gift Gift, entry LedgerEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	err = applyTransaction(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO gifts (GiftId, SenderAccountId, SenderDeviceCode, RecipientAccountId, TitleId, Amount, TransactionId, Message, Status, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		gift.GiftId, gift.SenderAccountId, gift.SenderDeviceCode, gift.RecipientAccountId, fmt.Sprintf("%016x", gift.TitleId), gift.Amount, gift.TransactionId, gift.Message, gift.Status, gift.CreatedAt)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetGift(giftId string) (*Gift, error) {
+	gifts, err := s.queryGifts(`SELECT GiftId, SenderAccountId, SenderDeviceCode, RecipientAccountId, TitleId, Amount, TransactionId, Message, Status, CreatedAt, ResolvedAt FROM gifts WHERE GiftId = ?`,
+		giftId)
+	if err != nil || len(gifts) == 0 {
+		return nil, err
+	}
+
+	return &gifts[0], nil
+}
+
+func (s *sqlStore) ListPendingGifts(recipientAccountId string) ([]Gift, error) {
+	return s.queryGifts(`SELECT GiftId, SenderAccountId, SenderDeviceCode, RecipientAccountId, TitleId, Amount, TransactionId, Message, Status, CreatedAt, ResolvedAt FROM gifts WHERE RecipientAccountId = ? AND Status = ? ORDER BY CreatedAt`,
+		recipientAccountId, GiftPending)
+}
+
+// queryGifts runs the given query, interpreting all rows as gifts.
+func (s *sqlStore) queryGifts(query string, args ...interface{}) ([]Gift, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gifts []Gift
+	for rows.Next() {
+		var gift Gift
+		var titleId string
+		err := rows.Scan(&gift.GiftId, &gift.SenderAccountId, &gift.SenderDeviceCode, &gift.RecipientAccountId, &titleId, &gift.Amount, &gift.TransactionId, &gift.Message, &gift.Status, &gift.CreatedAt, &gift.ResolvedAt)
+		if err != nil {
+			log.Printf("error scanning row: %v\n", err)
+			return nil, errors.New("failed to execute db operation")
+		}
+
+		gift.TitleId, err = parseTitleId(titleId)
+		if err != nil {
+			return nil, errors.New("stored title ID is malformed")
+		}
+
+		gifts = append(gifts, gift)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("error iterating rows: %v\n", err)
+		return nil, errors.New("failed to execute db operation")
+	}
+	return gifts, nil
+}
+
+func (s *sqlStore) AcceptGift(gift Gift, owned OwnedTitle) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	err = resolveGift(tx, gift.GiftId, GiftAccepted, owned.PurchaseDate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO owned_titles (TicketId, AccountId, TitleId, Version, RevokeDate, PurchaseDate, TransactionId) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		fmt.Sprint(owned.TicketId), gift.RecipientAccountId, fmt.Sprintf("%016x", owned.TitleId), owned.Version, owned.RevokeDate, owned.PurchaseDate, owned.TransactionId)
+	if err != nil {
+		// Recipients may have bought the title themselves since it was sent.
+		if s.isDuplicate(err) {
+			return errTitleAlreadyOwned
+		}
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+func (s *sqlStore) DeclineGift(gift Gift, refund LedgerEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("error beginning transaction: %v\n", err)
+		return errors.New("failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	err = resolveGift(tx, gift.GiftId, GiftDeclined, refund.Date)
+	if err != nil {
+		return err
+	}
+
+	err = applyTransaction(tx, refund)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error committing transaction: %v\n", err)
+		return errors.New("failed to commit transaction")
+	}
+
+	return nil
+}
+
+// resolveGift moves a pending gift to the given status within tx, returning errGiftResolved if it is no longer pending.
+// Only updating pending gifts avoids a gift being both accepted and declined.
+func resolveGift(tx *sql.Tx, giftId string, status string, resolvedAt int64) error {
+	result, err := tx.Exec(`UPDATE gifts SET Status = ?, ResolvedAt = ? WHERE GiftId = ? AND Status = ?`, status, resolvedAt, giftId, GiftPending)
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("error executing statement: %v\n", err)
+		return errors.New("failed to execute db operation")
+	}
+	if affected == 0 {
+		return errGiftResolved
+	}
+
+	return nil
+}
+
+func (s *sqlStore) GetCatalogTitle(titleId uint64) (*CatalogTitle, error) {
+	titles, err := s.queryCatalog(`SELECT TitleId, Name, Price, Region, Countries, Platform, Version, ReleaseDate, Size, Ratings FROM catalog WHERE TitleId = ?`,
+		fmt.Sprintf("%016x", titleId))
+	if err != nil || len(titles) == 0 {
+		return nil, err
+	}
+
+	return &titles[0], nil
+}
+
+func (s *sqlStore) ListCatalogTitles(region string, platform string) ([]CatalogTitle, error) {
+	if platform == "" {
+		return s.queryCatalog(`SELECT TitleId, Name, Price, Region, Countries, Platform, Version, ReleaseDate, Size, Ratings FROM catalog WHERE Region = ? ORDER BY ReleaseDate DESC`,
+			region)
+	}
+	return s.queryCatalog(`SELECT TitleId, Name, Price, Region, Countries, Platform, Version, ReleaseDate, Size, Ratings FROM catalog WHERE Region = ? AND Platform = ? ORDER BY ReleaseDate DESC`,
+		region, platform)
+}
+
+// queryCatalog runs the given query, interpreting all rows as catalog titles.
+func (s *sqlStore) queryCatalog(query string, args ...interface{}) ([]CatalogTitle, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []CatalogTitle
+	for rows.Next() {
+		var title CatalogTitle
+		var titleId, countries, ratings string
+		err := rows.Scan(&titleId, &title.Name, &title.Price, &title.Region, &countries, &title.Platform, &title.Version, &title.ReleaseDate, &title.Size, &ratings)
+		if err != nil {
+			log.Printf("error scanning row: %v\n", err)
+			return nil, errors.New("failed to execute db operation")
+		}
+
+		title.TitleId, err = parseTitleId(titleId)
+		if err != nil {
+			return nil, errors.New("stored title ID is malformed")
+		}
+		title.Countries = splitList(countries)
+		title.Ratings = splitList(ratings)
+
+		titles = append(titles, title)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("error iterating rows: %v\n", err)
+		return nil, errors.New("failed to execute db operation")
+	}
+	return titles, nil
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,45 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteStore opens the SQLite database within the config, creating it if necessary.
+func newSQLiteStore(config Config) (Store, error) {
+	db, err := sql.Open("sqlite3", "file:"+config.SQLitePath+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only permits a single writer, so we avoid contention entirely.
+	db.SetMaxOpenConns(1)
+
+	return &sqlStore{
+		db:          db,
+		isDuplicate: isSQLiteDuplicate,
+		sqlite:      true,
+	}, nil
+}
+
+// isSQLiteDuplicate returns whether an error was caused by violating a unique or primary key.
+func isSQLiteDuplicate(err error) bool {
+	driverErr, ok := err.(sqlite3.Error)
+	return ok && (driverErr.ExtendedCode == sqlite3.ErrConstraintUnique || driverErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -34,6 +34,68 @@ type Config struct {
 	SQLUser    string `xml:"SQLUser"`
 	SQLPass    string `xml:"SQLPass"`
 	SQLDB      string `xml:"SQLDB"`
+
+	// Database selects the storage backend, either "mysql" or "sqlite". MySQL is used if unset.
+	Database string `xml:"Database"`
+	// SQLitePath is the database file used with the SQLite backend.
+	SQLitePath string `xml:"SQLitePath"`
+
+	// DeviceTokenLifetime is the number of days a device token remains valid for, or 0 for no expiry.
+	DeviceTokenLifetime int `xml:"DeviceTokenLifetime"`
+	// DeviceTokenRenewalPeriod is the number of days after expiring a device token may still be renewed for.
+	DeviceTokenRenewalPeriod int `xml:"DeviceTokenRenewalPeriod"`
+
+	// ECardKey is the hex-encoded secret, at least 32 bytes, used to hash Wii Points Card codes.
+	// Changing it invalidates every card issued beforehand.
+	ECardKey string `xml:"ECardKey"`
+
+	// CommonKey is the hex-encoded key used to encrypt title keys within tickets.
+	CommonKey string `xml:"CommonKey"`
+	// XSKey is the path to a PEM-encoded RSA-2048 key used to sign tickets.
+	XSKey string `xml:"XSKey"`
+	// CertChain is the path to the XS and CA certificates, concatenated.
+	CertChain string `xml:"CertChain"`
+	// MSCert is the path to the certificate which signs console device certificates, itself signed by the CA.
+	MSCert string `xml:"MSCert"`
+
+	// ContentPrefixURL is where consoles are told to download title contents from.
+	ContentPrefixURL string `xml:"ContentPrefixURL"`
+	// ContentPath is the directory title contents are served from, laid out as <titleid>/<file>.
+	ContentPath string `xml:"ContentPath"`
+	// SystemTitles lists the system titles consoles should have installed, per region.
+	SystemTitles []SystemRegion `xml:"SystemTitles>Region"`
+
+	// PaymentProvider selects how points are paid for, either "free" or "http". Purchasing points is disabled if unset.
+	PaymentProvider string `xml:"PaymentProvider"`
+	// PaymentGatewayURL is where the http payment provider sends payments.
+	PaymentGatewayURL string `xml:"PaymentGatewayURL"`
+	// PointsPrices lists the points which may be purchased, alongside what is charged for them.
+	PointsPrices []PointsPrice `xml:"PointsPrices>Price"`
+	// PointsCap is the most points an account may hold, or 0 for no limit.
+	PointsCap int `xml:"PointsCap"`
+}
+
+// SystemRegion describes the system titles for a single region.
+type SystemRegion struct {
+	Name   string        `xml:"name,attr"`
+	Titles []SystemTitle `xml:"Title"`
+}
+
+// SystemTitle describes a system title and the version consoles should have installed.
+type SystemTitle struct {
+	TitleId string `xml:"id,attr"`
+	Version uint16 `xml:"version,attr"`
+	Size    int64  `xml:"size,attr"`
+}
+
+// PointsPrice describes points which may be purchased, and the price charged for them.
+type PointsPrice struct {
+	// ItemId identifies this price within PurchasePoints. Consoles which send none are matched by points and currency.
+	ItemId string `xml:"itemId,attr"`
+	Points int    `xml:"points,attr"`
+	// Amount must be written exactly as consoles display it, such as 10.00.
+	Amount   string `xml:"amount,attr"`
+	Currency string `xml:"currency,attr"`
 }
 
 // Envelope represents the root element of any response, soapenv:Envelope.
@@ -48,6 +110,8 @@ type Envelope struct {
 
 	// Used for internal state tracking.
 	action string
+	// language is used to translate error reasons, if the request specified one.
+	language string
 }
 
 // Body represents the nested soapenv:Body element as a child on the root element,
@@ -60,6 +124,7 @@ type Body struct {
 }
 
 // Response describes the inner response format, along with common fields across requests.
+// It is marshalled by MarshalXML, which flattens Payload alongside the common fields.
 type Response struct {
 	XMLName xml.Name
 	XMLNS   string `xml:"xmlns,attr"`
@@ -72,21 +137,40 @@ type Response struct {
 	ErrorCode          int
 	ServiceStandbyMode bool `xml:"ServiceStandbyMode"`
 
-	// Allows for <name>[dynamic content]</name> situations.
-	CustomFields []interface{}
+	// Payload is the action-specific response, such as a CheckDeviceStatusResponse.
+	Payload interface{} `xml:"-"`
 }
 
-// KVField represents an individual node in form of <XMLName>Contents</XMLName>.
-type KVField struct {
-	XMLName xml.Name
-	Value   string `xml:",chardata"`
+// FaultEnvelope is returned in place of an Envelope when a request could not be handled at all,
+// such as for unknown actions or malformed bodies. Errors within an action use ErrorCode instead.
+type FaultEnvelope struct {
+	XMLName string `xml:"soapenv:Envelope"`
+	SOAPEnv string `xml:"xmlns:soapenv,attr"`
+
+	Body FaultBody
+}
+
+// FaultBody represents the soapenv:Body within a FaultEnvelope, containing only the fault.
+type FaultBody struct {
+	XMLName string `xml:"soapenv:Body"`
+
+	Fault Fault
+}
+
+// Fault represents a SOAP 1.1 soapenv:Fault.
+type Fault struct {
+	XMLName string `xml:"soapenv:Fault"`
+	// FaultCode is either FaultCodeClient or FaultCodeServer, depending on which side is responsible.
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+	Detail      string `xml:"detail,omitempty"`
 }
 
 // Balance represents a common XML structure.
 type Balance struct {
 	XMLName  xml.Name `xml:"Balance"`
 	Amount   int      `xml:"Amount"`
-	Currency string   `xml"Currency"`
+	Currency string   `xml:"Currency"`
 }
 
 // Transactions represents a common XML structure.
@@ -95,4 +179,478 @@ type Transactions struct {
 	TransactionId string   `xml:"TransactionId"`
 	Date          string   `xml:"Date"`
 	Type          string   `xml:"Type"`
+	// TitleId and Points are only reported within ListPurchaseHistory.
+	TitleId string `xml:"TitleId,omitempty"`
+	Points  int    `xml:"Points,omitempty"`
+}
+
+// Tickets represents a common XML structure.
+type Tickets struct {
+	XMLName      xml.Name `xml:"Tickets"`
+	TicketId     string   `xml:"TicketId"`
+	TitleId      string   `xml:"TitleId"`
+	RevokeDate   int64    `xml:"RevokeDate"`
+	Version      uint16   `xml:"Version"`
+	MigrateCount int      `xml:"MigrateCount"`
+	MigrateLimit int      `xml:"MigrateLimit"`
+}
+
+// Price represents a common XML structure.
+type Price struct {
+	XMLName  xml.Name `xml:"Price"`
+	Amount   int      `xml:"Amount"`
+	Currency string   `xml:"Currency"`
+}
+
+// Rating represents a common XML structure.
+type Rating struct {
+	XMLName xml.Name `xml:"Rating"`
+	Name    string   `xml:"Name"`
+	Value   string   `xml:"Value"`
+}
+
+// TitleInfo represents a common XML structure.
+type TitleInfo struct {
+	XMLName     xml.Name `xml:"TitleInfo"`
+	TitleId     string   `xml:"TitleId"`
+	TitleName   string   `xml:"TitleName"`
+	Platform    string   `xml:"Platform"`
+	Version     uint16   `xml:"Version"`
+	ReleaseDate int64    `xml:"ReleaseDate"`
+	TitleSize   int64    `xml:"TitleSize"`
+	Price       Price
+	Ratings     []Rating
+}
+
+// ContentSets represents a common XML structure.
+type ContentSets struct {
+	XMLName xml.Name `xml:"ContentSets"`
+	TitleId string   `xml:"TitleId"`
+	Version uint16   `xml:"Version"`
+	FsSize  int64    `xml:"FsSize"`
+}
+
+// GiftInfo represents a common XML structure.
+type GiftInfo struct {
+	XMLName          xml.Name `xml:"Gifts"`
+	GiftId           string   `xml:"GiftId"`
+	TitleId          string   `xml:"TitleId"`
+	SenderDeviceCode string   `xml:"SenderDeviceCode"`
+	Message          string   `xml:"Message"`
+	Date             int64    `xml:"Date"`
+}
+
+// TitleVersion represents a common XML structure.
+type TitleVersion struct {
+	XMLName xml.Name `xml:"TitleVersion"`
+	TitleId string   `xml:"TitleId"`
+	Version uint16   `xml:"Version"`
+	FsSize  int64    `xml:"FsSize"`
+}
+
+/////////////////////////
+// RESPONSE STRUCTURES //
+/////////////////////////
+// Fields are encoded in the order they are declared, which must match the official schema.
+// The Wii Shop Channel is unforgiving of unexpected ordering.
+
+// ErrorResponse is the payload of any action which failed, alongside its ErrorCode.
+type ErrorResponse struct {
+	UserReason   string `xml:"UserReason"`
+	ServerReason string `xml:"ServerReason"`
+}
+
+// CheckDeviceStatusResponse is the response for ecs/CheckDeviceStatus.
+type CheckDeviceStatusResponse struct {
+	Balance       Balance
+	ForceSyncTime int    `xml:"ForceSyncTime"`
+	ExtTicketTime string `xml:"ExtTicketTime"`
+	SyncTime      string `xml:"SyncTime"`
+}
+
+// CheckAccountBalanceResponse is the response for ecs/CheckAccountBalance.
+type CheckAccountBalanceResponse struct {
+	Balance Balance
+	// MaxBalance is the most points the account may hold, or 0 should there be no limit.
+	MaxBalance int `xml:"MaxBalance"`
+}
+
+// NotifyETicketsSyncedResponse is the response for ecs/NotifyETicketsSynced.
+type NotifyETicketsSyncedResponse struct{}
+
+// ListETicketsResponse is the response for ecs/ListETickets.
+type ListETicketsResponse struct {
+	ForceSyncTime int       `xml:"ForceSyncTime"`
+	ExtTicketTime string    `xml:"ExtTicketTime"`
+	SyncTime      string    `xml:"SyncTime"`
+	Tickets       []Tickets `xml:"Tickets"`
+}
+
+// GetETicketsResponse is the response for ecs/GetETickets.
+type GetETicketsResponse struct {
+	ForceSyncTime int `xml:"ForceSyncTime"`
+	// ETickets and Certs are base64-encoded.
+	ETickets []string `xml:"ETickets"`
+	Certs    []string `xml:"Certs"`
+}
+
+// PurchaseTitleResponse is the response for ecs/PurchaseTitle.
+type PurchaseTitleResponse struct {
+	Balance      Balance
+	Transactions Transactions
+	SyncTime     string   `xml:"SyncTime"`
+	Certs        []string `xml:"Certs"`
+	TitleId      string   `xml:"TitleId"`
+	// ETickets is omitted for gifts, which are only issued once accepted.
+	ETickets string `xml:"ETickets,omitempty"`
+}
+
+// PurchasePointsResponse is the response for ecs/PurchasePoints.
+type PurchasePointsResponse struct {
+	Balance      Balance
+	Transactions Transactions
+}
+
+// CheckECardBalanceResponse is the response for ecs/CheckECardBalance.
+type CheckECardBalanceResponse struct {
+	// Balance is the value of the card, rather than the account.
+	Balance Balance
+}
+
+// RedeemECardResponse is the response for ecs/RedeemECard.
+type RedeemECardResponse struct {
+	Balance      Balance
+	Transactions Transactions
+}
+
+// ListGiftsResponse is the response for ecs/ListGifts.
+type ListGiftsResponse struct {
+	Gifts []GiftInfo `xml:"Gifts"`
+}
+
+// AcceptGiftResponse is the response for ecs/AcceptGift.
+type AcceptGiftResponse struct {
+	SyncTime string   `xml:"SyncTime"`
+	Certs    []string `xml:"Certs"`
+	TitleId  string   `xml:"TitleId"`
+	ETickets string   `xml:"ETickets"`
+}
+
+// DeclineGiftResponse is the response for ecs/DeclineGift.
+type DeclineGiftResponse struct{}
+
+// ListPurchaseHistoryResponse is the response for ecs/ListPurchaseHistory.
+type ListPurchaseHistoryResponse struct {
+	ListResultTotalSize int            `xml:"ListResultTotalSize"`
+	Transactions        []Transactions `xml:"Transactions"`
+}
+
+// ListTitlesResponse is the response for cas/ListTitles.
+type ListTitlesResponse struct {
+	ListResultTotalSize int         `xml:"ListResultTotalSize"`
+	TitleInfos          []TitleInfo `xml:"TitleInfo"`
+}
+
+// ListContentSetsResponse is the response for cas/ListContentSets.
+type ListContentSetsResponse struct {
+	ListResultTotalSize int           `xml:"ListResultTotalSize"`
+	ContentSets         []ContentSets `xml:"ContentSets"`
+}
+
+// GetTitleDetailsResponse is the response for cas/GetTitleDetails.
+type GetTitleDetailsResponse struct {
+	TitleInfo TitleInfo
+}
+
+// GetSystemUpdateResponse is the response for nus/GetSystemUpdate.
+type GetSystemUpdateResponse struct {
+	ContentPrefixURL         string         `xml:"ContentPrefixURL"`
+	UncachedContentPrefixURL string         `xml:"UncachedContentPrefixURL"`
+	TitleVersions            []TitleVersion `xml:"TitleVersion"`
+	UploadAuditData          int            `xml:"UploadAuditData"`
+}
+
+// GetSystemTitleHashResponse is the response for nus/GetSystemTitleHash.
+type GetSystemTitleHashResponse struct {
+	TitleHash string `xml:"TitleHash"`
+}
+
+// GetSystemCommonETicketResponse is the response for nus/GetSystemCommonETicket.
+type GetSystemCommonETicketResponse struct {
+	// CommonETickets and Certs are base64-encoded.
+	CommonETickets []string `xml:"CommonETicket"`
+	Certs          []string `xml:"Certs"`
+}
+
+// CheckRegistrationResponse is the response for ias/CheckRegistration.
+type CheckRegistrationResponse struct {
+	OriginalSerialNumber string `xml:"OriginalSerialNumber"`
+	DeviceStatus         string `xml:"DeviceStatus"`
+}
+
+// GetChallengeResponse is the response for ias/GetChallenge.
+type GetChallengeResponse struct {
+	Challenge string `xml:"Challenge"`
+}
+
+// GetRegistrationInfoResponse is the response for ias/GetRegistrationInfo.
+type GetRegistrationInfoResponse struct {
+	AccountId          string `xml:"AccountId"`
+	DeviceToken        string `xml:"DeviceToken"`
+	DeviceTokenExpired bool   `xml:"DeviceTokenExpired"`
+	Country            string `xml:"Country"`
+	ExtAccountId       string `xml:"ExtAccountId"`
+	DeviceCode         string `xml:"DeviceCode"`
+	DeviceStatus       string `xml:"DeviceStatus"`
+	// This _must_ be POINTS.
+	Currency string `xml:"Currency"`
+}
+
+// RegisterResponse is the response for ias/Register.
+type RegisterResponse struct {
+	AccountId          string `xml:"AccountId"`
+	DeviceToken        string `xml:"DeviceToken"`
+	DeviceTokenExpired bool   `xml:"DeviceTokenExpired"`
+	Country            string `xml:"Country"`
+	ExtAccountId       string `xml:"ExtAccountId"`
+	DeviceCode         string `xml:"DeviceCode"`
+}
+
+// SyncRegistrationResponse is the response for ias/SyncRegistration.
+type SyncRegistrationResponse struct {
+	AccountId          string `xml:"AccountId"`
+	DeviceToken        string `xml:"DeviceToken"`
+	DeviceTokenExpired bool   `xml:"DeviceTokenExpired"`
+	Country            string `xml:"Country"`
+	ExtAccountId       string `xml:"ExtAccountId"`
+	DeviceCode         string `xml:"DeviceCode"`
+	DeviceStatus       string `xml:"DeviceStatus"`
+	// This _must_ be POINTS.
+	Currency string `xml:"Currency"`
+}
+
+// UnregisterResponse is the response for ias/Unregister.
+type UnregisterResponse struct{}
+
+////////////////////////
+// REQUEST STRUCTURES //
+////////////////////////
+// Requests are decoded by decodeRequest, with keys named after their fields unless tagged otherwise.
+
+// CommonRequest holds the keys sent with every request, regardless of service.
+type CommonRequest struct {
+	Version   string `soap:"Version"`
+	DeviceId  string `soap:"DeviceId"`
+	MessageId string `soap:"MessageId"`
+	// Not all requests specify a language, in which case errors are reported in English.
+	Language string `soap:"Language,optional"`
+}
+
+// AccountRequest holds the keys identifying a registered account.
+// Requests for actions requiring authentication must embed it.
+type AccountRequest struct {
+	AccountId   string `soap:"AccountId"`
+	DeviceToken string `soap:"DeviceToken"`
+}
+
+// accountRequester is implemented by requests embedding AccountRequest.
+type accountRequester interface {
+	accountRequest() *AccountRequest
+}
+
+func (a *AccountRequest) accountRequest() *AccountRequest {
+	return a
+}
+
+// ListRequest holds the keys used to page through a list. A limit of 0 requests all remaining results.
+type ListRequest struct {
+	ListResultOffset uint32 `soap:"ListResultOffset,optional"`
+	ListResultLimit  uint32 `soap:"ListResultLimit,optional"`
+}
+
+// PriceRequest is the price a console was shown, repeated back when purchasing.
+type PriceRequest struct {
+	Amount int `soap:"Amount"`
+}
+
+// CheckDeviceStatusRequest is the request for ecs/CheckDeviceStatus.
+type CheckDeviceStatusRequest struct {
+	AccountRequest
+}
+
+// CheckAccountBalanceRequest is the request for ecs/CheckAccountBalance.
+type CheckAccountBalanceRequest struct {
+	AccountRequest
+}
+
+// NotifyETicketsSyncedRequest is the request for ecs/NotifyETicketsSynced.
+type NotifyETicketsSyncedRequest struct {
+	AccountRequest
+}
+
+// ListETicketsRequest is the request for ecs/ListETickets.
+type ListETicketsRequest struct {
+	AccountRequest
+}
+
+// GetETicketsRequest is the request for ecs/GetETickets.
+type GetETicketsRequest struct {
+	AccountRequest
+	TicketIds []uint64 `soap:"TicketId"`
+}
+
+// PurchaseTitleRequest is the request for ecs/PurchaseTitle.
+type PurchaseTitleRequest struct {
+	AccountRequest
+	TitleId string       `soap:"TitleId"`
+	Price   PriceRequest `soap:"Price"`
+	// RecipientDeviceCode is the Wii Number of another console, should this title be a gift.
+	RecipientDeviceCode string `soap:"RecipientDeviceCode,optional"`
+	GiftMessage         string `soap:"GiftMessage,optional"`
+}
+
+// MoneyRequest is a price in real currency, such as 10.00 USD.
+type MoneyRequest struct {
+	Amount   string `soap:"Amount"`
+	Currency string `soap:"Currency"`
+}
+
+// PaymentRequest describes how a console intends to pay.
+type PaymentRequest struct {
+	PaymentMethod string `soap:"PaymentMethod"`
+}
+
+// PurchasePointsRequest is the request for ecs/PurchasePoints.
+type PurchasePointsRequest struct {
+	AccountRequest
+	PointsToPurchase int `soap:"PointsToPurchase"`
+	// ItemId identifies the points card chosen within the Shop.
+	ItemId  string         `soap:"ItemId,optional"`
+	Price   MoneyRequest   `soap:"Price"`
+	Payment PaymentRequest `soap:"Payment"`
+}
+
+// CheckECardBalanceRequest is the request for ecs/CheckECardBalance.
+type CheckECardBalanceRequest struct {
+	AccountRequest
+	// ECardId is the code printed on a Wii Points Card, as typed by the user.
+	ECardId string `soap:"ECardId"`
+}
+
+// RedeemECardRequest is the request for ecs/RedeemECard.
+type RedeemECardRequest struct {
+	AccountRequest
+	ECardId string `soap:"ECardId"`
+}
+
+// ListGiftsRequest is the request for ecs/ListGifts.
+type ListGiftsRequest struct {
+	AccountRequest
+}
+
+// AcceptGiftRequest is the request for ecs/AcceptGift.
+type AcceptGiftRequest struct {
+	AccountRequest
+	GiftId string `soap:"GiftId"`
+}
+
+// DeclineGiftRequest is the request for ecs/DeclineGift.
+type DeclineGiftRequest struct {
+	AccountRequest
+	GiftId string `soap:"GiftId"`
+}
+
+// ListPurchaseHistoryRequest is the request for ecs/ListPurchaseHistory.
+type ListPurchaseHistoryRequest struct {
+	AccountRequest
+	ListRequest
+}
+
+// ListTitlesRequest is the request for cas/ListTitles.
+type ListTitlesRequest struct {
+	ListRequest
+	Region  string `soap:"Region"`
+	Country string `soap:"Country"`
+	// Platform optionally narrows results.
+	Platform string `soap:"Platform,optional"`
+}
+
+// ListContentSetsRequest is the request for cas/ListContentSets.
+type ListContentSetsRequest struct {
+	ListRequest
+	Region   string `soap:"Region"`
+	Country  string `soap:"Country"`
+	Platform string `soap:"Platform,optional"`
+}
+
+// GetTitleDetailsRequest is the request for cas/GetTitleDetails.
+type GetTitleDetailsRequest struct {
+	TitleId string `soap:"TitleId"`
+}
+
+// NUSRequest holds the keys sent with every NUS request.
+type NUSRequest struct {
+	RegionId string `soap:"RegionId"`
+}
+
+// GetSystemUpdateRequest is the request for nus/GetSystemUpdate.
+type GetSystemUpdateRequest struct {
+	NUSRequest
+}
+
+// GetSystemTitleHashRequest is the request for nus/GetSystemTitleHash.
+type GetSystemTitleHashRequest struct {
+	NUSRequest
+}
+
+// GetSystemCommonETicketRequest is the request for nus/GetSystemCommonETicket.
+type GetSystemCommonETicketRequest struct {
+	NUSRequest
+	TitleIds []string `soap:"TitleId"`
+}
+
+// IASRequest holds the keys sent with every IAS request.
+type IASRequest struct {
+	Region   string `soap:"Region"`
+	Country  string `soap:"Country"`
+	Language string `soap:"Language"`
+}
+
+// CheckRegistrationRequest is the request for ias/CheckRegistration.
+type CheckRegistrationRequest struct {
+	IASRequest
+	SerialNumber string `soap:"SerialNumber"`
+}
+
+// GetChallengeRequest is the request for ias/GetChallenge.
+type GetChallengeRequest struct {
+	IASRequest
+}
+
+// GetRegistrationInfoRequest is the request for ias/GetRegistrationInfo.
+type GetRegistrationInfoRequest struct {
+	IASRequest
+	AccountRequest
+}
+
+// RegisterRequest is the request for ias/Register.
+type RegisterRequest struct {
+	IASRequest
+	DeviceCode     string `soap:"DeviceCode"`
+	RegisterRegion string `soap:"RegisterRegion"`
+	SerialNumber   string `soap:"SerialNumber"`
+	// DeviceCert is the base64-encoded certificate of the registering console.
+	DeviceCert string `soap:"DeviceCert"`
+}
+
+// SyncRegistrationRequest is the request for ias/SyncRegistration.
+type SyncRegistrationRequest struct {
+	IASRequest
+	AccountRequest
+}
+
+// UnregisterRequest is the request for ias/Unregister.
+type UnregisterRequest struct {
+	IASRequest
+	AccountRequest
 }
@@ -0,0 +1,286 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	// TicketIssuer is the signer of all tickets we issue.
+	TicketIssuer = "Root-CA00000001-XS00000003"
+
+	// SignatureRSA2048 is the signature type of a ticket signed with an RSA-2048 key over SHA-1.
+	SignatureRSA2048 = 0x00010001
+
+	// ticketSize is the length of a v0 ticket, signature included.
+	ticketSize = 0x2A4
+	// ticketSignedOffset is where the signed portion of a ticket begins, beginning with the issuer.
+	ticketSignedOffset = 0x140
+)
+
+var (
+	// commonKey is used to encrypt title keys within tickets.
+	commonKey []byte
+	// xsKey signs all tickets we issue.
+	xsKey *rsa.PrivateKey
+	// certChain contains the XS and CA certificates, in that order, sent alongside tickets.
+	certChain [][]byte
+)
+
+// TicketLimit describes a single play limit within a ticket, such as a time limit.
+type TicketLimit struct {
+	Type  uint32
+	Value uint32
+}
+
+// Ticket describes the fields we care about within a Wii ticket.
+type Ticket struct {
+	TicketId     uint64
+	ConsoleId    uint32
+	TitleId      uint64
+	TitleVersion uint16
+	// TitleKey is the decrypted title key for this title.
+	TitleKey [16]byte
+	// AccessMask determines which contents may be used with this ticket, one bit per content index.
+	AccessMask [64]byte
+	Limits     [8]TicketLimit
+}
+
+// rawTicket mirrors the on-disk layout of a v0 ticket.
+type rawTicket struct {
+	SignatureType            uint32
+	Signature                [256]byte
+	_                        [60]byte
+	Issuer                   [64]byte
+	ECDHData                 [60]byte
+	FormatVersion            uint8
+	_                        [2]byte
+	EncryptedTitleKey        [16]byte
+	_                        uint8
+	TicketId                 uint64
+	ConsoleId                uint32
+	TitleId                  uint64
+	SystemAccessMask         uint16
+	TitleVersion             uint16
+	PermittedTitlesMask      uint32
+	PermitMask               uint32
+	TitleExportAllowed       uint8
+	CommonKeyIndex           uint8
+	_                        [48]byte
+	ContentAccessPermissions [64]byte
+	_                        uint16
+	Limits                   [8]TicketLimit
+}
+
+// loadTicketKeys reads the common key, XS signing key and certificate chain from the config.
+func loadTicketKeys(config Config) error {
+	var err error
+	commonKey, err = hex.DecodeString(config.CommonKey)
+	if err != nil {
+		return err
+	}
+	if len(commonKey) != 16 {
+		return errors.New("common key must be 16 bytes")
+	}
+
+	contents, err := ioutil.ReadFile(config.XSKey)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return errors.New("XS key is not PEM encoded")
+	}
+	xsKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if xsKey.N.BitLen() != 2048 {
+		return errors.New("XS key must be RSA-2048")
+	}
+
+	contents, err = ioutil.ReadFile(config.CertChain)
+	if err != nil {
+		return err
+	}
+	certChain, err = splitCertificates(contents)
+	return err
+}
+
+// encodedCertChain returns certChain base64-encoded, as sent alongside tickets within Certs.
+func encodedCertChain() []string {
+	var certs []string
+	for _, cert := range certChain {
+		certs = append(certs, base64.StdEncoding.EncodeToString(cert))
+	}
+	return certs
+}
+
+// titleKeyIV returns the IV used to encrypt a title key, which is its title ID padded with zeros.
+func titleKeyIV(titleId uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv, titleId)
+	return iv
+}
+
+// Bytes returns a signed ticket, ready to be sent to a console.
+func (t *Ticket) Bytes() ([]byte, error) {
+	raw := rawTicket{
+		SignatureType:            SignatureRSA2048,
+		TicketId:                 t.TicketId,
+		ConsoleId:                t.ConsoleId,
+		TitleId:                  t.TitleId,
+		SystemAccessMask:         0xFFFF,
+		TitleVersion:             t.TitleVersion,
+		ContentAccessPermissions: t.AccessMask,
+		Limits:                   t.Limits,
+	}
+	copy(raw.Issuer[:], TicketIssuer)
+
+	// The title key is encrypted with the common key.
+	block, err := aes.NewCipher(commonKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, titleKeyIV(t.TitleId)).CryptBlocks(raw.EncryptedTitleKey[:], t.TitleKey[:])
+
+	buf := new(bytes.Buffer)
+	err = binary.Write(buf, binary.BigEndian, raw)
+	if err != nil {
+		return nil, err
+	}
+	contents := buf.Bytes()
+
+	// Lastly, sign everything from the issuer onwards.
+	digest := sha1.Sum(contents[ticketSignedOffset:])
+	signature, err := rsa.SignPKCS1v15(nil, xsKey, crypto.SHA1, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	copy(contents[4:], signature)
+
+	return contents, nil
+}
+
+// parseTicket interprets a ticket we have issued, verifying its signature and decrypting its title key.
+func parseTicket(contents []byte) (*Ticket, error) {
+	if len(contents) != ticketSize {
+		return nil, errors.New("ticket is not the expected size")
+	}
+
+	raw := rawTicket{}
+	err := binary.Read(bytes.NewReader(contents), binary.BigEndian, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.SignatureType != SignatureRSA2048 {
+		return nil, errors.New("unknown ticket signature type")
+	}
+
+	digest := sha1.Sum(contents[ticketSignedOffset:])
+	err = rsa.VerifyPKCS1v15(&xsKey.PublicKey, crypto.SHA1, digest[:], raw.Signature[:])
+	if err != nil {
+		return nil, err
+	}
+
+	t := Ticket{
+		TicketId:     raw.TicketId,
+		ConsoleId:    raw.ConsoleId,
+		TitleId:      raw.TitleId,
+		TitleVersion: raw.TitleVersion,
+		AccessMask:   raw.ContentAccessPermissions,
+		Limits:       raw.Limits,
+	}
+
+	block, err := aes.NewCipher(commonKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, titleKeyIV(raw.TitleId)).CryptBlocks(t.TitleKey[:], raw.EncryptedTitleKey[:])
+
+	return &t, nil
+}
+
+// NewTicket returns a ticket for the given title, personalised to a console.
+// All contents are accessible, and no limits are applied.
+func NewTicket(ticketId uint64, titleId uint64, consoleId uint32, titleKey [16]byte) *Ticket {
+	t := Ticket{
+		TicketId:  ticketId,
+		ConsoleId: consoleId,
+		TitleId:   titleId,
+		TitleKey:  titleKey,
+	}
+
+	for i := range t.AccessMask {
+		t.AccessMask[i] = 0xFF
+	}
+
+	return &t
+}
+
+// generateTicketId returns a random ticket ID for a newly issued ticket.
+func generateTicketId() (uint64, error) {
+	var ticketId uint64
+	err := binary.Read(rand.Reader, binary.BigEndian, &ticketId)
+	return ticketId, err
+}
+
+// parseTitleId interprets a title ID in its usual 16 character hexadecimal form.
+func parseTitleId(titleId string) (uint64, error) {
+	if len(titleId) != 16 {
+		return 0, errors.New("title ID must be 16 characters")
+	}
+	return strconv.ParseUint(titleId, 16, 64)
+}
+
+// consoleIdFromDeviceId returns the console ID within a device ID.
+// The upper 32 bits of a device ID describe its platform, while the lower 32 bits are the console ID.
+func consoleIdFromDeviceId(deviceId string) (uint32, error) {
+	parsed, err := strconv.ParseUint(deviceId, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(parsed), nil
+}
+
+// getTitleKey returns the decrypted title key for a title.
+func getTitleKey(titleId uint64) ([16]byte, error) {
+	titleKey, err := store.GetTitleKey(titleId)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	if titleKey == nil {
+		return [16]byte{}, errors.New("no title key is known for this title")
+	}
+	return *titleKey, nil
+}
@@ -0,0 +1,170 @@
+//	Copyright (C) 2018-2020 CornierKhan1
+//
+//	WiiSOAP is SOAP Server Software, designed specifically to handle Wii Shop Channel SOAP.
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU Affero General Public License as published
+//    by the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU Affero General Public License for more details.
+//
+//    You should have received a copy of the GNU Affero General Public License
+//    along with this program.  If not, see http://www.gnu.org/licenses/.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"testing"
+)
+
+// setupTicketKeys installs a throwaway common key and XS key, returning a function restoring the previous ones.
+func setupTicketKeys(t *testing.T) func() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousCommonKey, previousXSKey := commonKey, xsKey
+	commonKey = bytes.Repeat([]byte{0x5A}, 16)
+	xsKey = key
+	return func() {
+		commonKey, xsKey = previousCommonKey, previousXSKey
+	}
+}
+
+func testTicket() *Ticket {
+	titleKey := [16]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	ticket := NewTicket(0x0123456789ABCDEF, 0x0001000146414245, 0x04021F3A, titleKey)
+	ticket.TitleVersion = 513
+	ticket.AccessMask[63] = 0x0F
+	ticket.Limits[0] = TicketLimit{Type: 1, Value: 60}
+	return ticket
+}
+
+func TestTicketRoundTrip(t *testing.T) {
+	defer setupTicketKeys(t)()
+
+	ticket := testTicket()
+	contents, err := ticket.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseTicket(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *ticket {
+		t.Errorf("parsed ticket differs:\ngot  %+v\nwant %+v", *parsed, *ticket)
+	}
+}
+
+func TestTicketLayout(t *testing.T) {
+	defer setupTicketKeys(t)()
+
+	ticket := testTicket()
+	contents, err := ticket.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 0x2A4 {
+		t.Fatalf("ticket is %#x bytes, want 0x2A4", len(contents))
+	}
+
+	if got := binary.BigEndian.Uint32(contents[0x000:]); got != SignatureRSA2048 {
+		t.Errorf("signature type is %#x, want %#x", got, SignatureRSA2048)
+	}
+	if got := string(bytes.TrimRight(contents[0x140:0x180], "\x00")); got != TicketIssuer {
+		t.Errorf("issuer is %q, want %q", got, TicketIssuer)
+	}
+	if got := binary.BigEndian.Uint64(contents[0x1D0:]); got != ticket.TicketId {
+		t.Errorf("ticket ID is %#x, want %#x", got, ticket.TicketId)
+	}
+	if got := binary.BigEndian.Uint32(contents[0x1D8:]); got != ticket.ConsoleId {
+		t.Errorf("console ID is %#x, want %#x", got, ticket.ConsoleId)
+	}
+	if got := binary.BigEndian.Uint64(contents[0x1DC:]); got != ticket.TitleId {
+		t.Errorf("title ID is %#x, want %#x", got, ticket.TitleId)
+	}
+	if got := binary.BigEndian.Uint16(contents[0x1E6:]); got != ticket.TitleVersion {
+		t.Errorf("title version is %d, want %d", got, ticket.TitleVersion)
+	}
+	if got := contents[0x222 : 0x222+64]; !bytes.Equal(got, ticket.AccessMask[:]) {
+		t.Errorf("access mask is %x, want %x", got, ticket.AccessMask)
+	}
+	if got := binary.BigEndian.Uint32(contents[0x264+4:]); got != ticket.Limits[0].Value {
+		t.Errorf("first limit is %d, want %d", got, ticket.Limits[0].Value)
+	}
+
+	// The title key is stored encrypted with the common key, using the title ID as its IV.
+	block, err := aes.NewCipher(commonKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titleKey [16]byte
+	cipher.NewCBCDecrypter(block, titleKeyIV(ticket.TitleId)).CryptBlocks(titleKey[:], contents[0x1BF:0x1CF])
+	if titleKey != ticket.TitleKey {
+		t.Errorf("title key decrypts to %x, want %x", titleKey, ticket.TitleKey)
+	}
+	if bytes.Equal(contents[0x1BF:0x1CF], ticket.TitleKey[:]) {
+		t.Error("title key is stored unencrypted")
+	}
+}
+
+func TestTicketSignature(t *testing.T) {
+	defer setupTicketKeys(t)()
+
+	contents, err := testTicket().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"signature", 0x004},
+		{"issuer", 0x140},
+		{"ticket ID", 0x1D0},
+		{"title ID", 0x1DC},
+		{"access mask", 0x222},
+		{"limits", 0x2A3},
+	}
+	for _, test := range tests {
+		tampered := append([]byte(nil), contents...)
+		tampered[test.offset] ^= 0x01
+		if _, err := parseTicket(tampered); err == nil {
+			t.Errorf("ticket with a modified %s was accepted", test.name)
+		}
+	}
+
+	if _, err := parseTicket(contents[:len(contents)-1]); err == nil {
+		t.Error("truncated ticket was accepted")
+	}
+
+	// Tickets signed by another key must be rejected.
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := xsKey
+	xsKey = other
+	contents, err = testTicket().Bytes()
+	xsKey = signer
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTicket(contents); err == nil {
+		t.Error("ticket signed by another key was accepted")
+	}
+}
@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"github.com/antchfx/xmlquery"
 	"io"
-	"math/rand"
 	"regexp"
 	"time"
 )
@@ -83,36 +82,18 @@ func (e *Envelope) DeviceId() string {
 
 // ObtainCommon interprets a given node, and updates the envelope with common key values.
 func (e *Envelope) ObtainCommon(doc *xmlquery.Node) error {
-	var err error
-
-	// These fields are common across all requests.
-	e.Body.Response.Version, err = getKey(doc, "Version")
-	if err != nil {
-		return err
-	}
-	e.Body.Response.DeviceId, err = getKey(doc, "DeviceId")
-	if err != nil {
-		return err
-	}
-	e.Body.Response.MessageId, err = getKey(doc, "MessageId")
+	var common CommonRequest
+	err := decodeRequest(doc, &common)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// AddKVNode adds a given key by name to a specified value, such as <key>value</key>.
-func (e *Envelope) AddKVNode(key string, value string) {
-	e.Body.Response.CustomFields = append(e.Body.Response.CustomFields, KVField{
-		XMLName: xml.Name{Local: key},
-		Value:   value,
-	})
-}
+	e.Body.Response.Version = common.Version
+	e.Body.Response.DeviceId = common.DeviceId
+	e.Body.Response.MessageId = common.MessageId
+	e.language = common.Language
 
-// AddCustomType adds a given key by name to a specified structure.
-func (e *Envelope) AddCustomType(customType interface{}) {
-	e.Body.Response.CustomFields = append(e.Body.Response.CustomFields, customType)
+	return nil
 }
 
 // becomeXML marshals the Envelope object, returning the intended boolean state on success.
@@ -128,25 +109,43 @@ func (e *Envelope) becomeXML(intendedStatus bool) (bool, string) {
 	}
 }
 
-// ReturnSuccess returns a standard SOAP response with a positive error code.
-func (e *Envelope) ReturnSuccess() (bool, string) {
+// ReturnSuccess returns a standard SOAP response with a positive error code,
+// containing the given payload such as a CheckDeviceStatusResponse.
+func (e *Envelope) ReturnSuccess(payload interface{}) (bool, string) {
 	// Ensure the error code is 0.
 	e.Body.Response.ErrorCode = 0
+	e.Body.Response.Payload = payload
 
 	return e.becomeXML(true)
 }
 
 // ReturnError returns a standard SOAP response with an error code.
-func (e *Envelope) ReturnError(errorCode int, reason string, err error) (bool, string) {
-	e.Body.Response.ErrorCode = errorCode
+func (e *Envelope) ReturnError(shopError *ShopError, err error) (bool, string) {
+	e.Body.Response.ErrorCode = shopError.Code
 
-	// Ensure all additional fields are empty to avoid conflict.
-	e.Body.Response.CustomFields = nil
+	// Any payload is replaced to avoid conflict.
+	e.Body.Response.Payload = ErrorResponse{
+		UserReason:   shopError.Reason(e.language),
+		ServerReason: err.Error(),
+	}
 
-	e.AddKVNode("UserReason", reason)
-	e.AddKVNode("ServerReason", err.Error())
+	// The request itself was understood, so this is a regular response with ErrorCode set rather than a fault.
+	fmt.Printf("Failed to handle request: %v (error code %d)\n", err, shopError.Code)
+	return e.becomeXML(true)
+}
 
-	return e.becomeXML(false)
+// NewFault returns a SOAP fault, as described by the given code and reason.
+func NewFault(faultCode string, reason string, detail string) FaultEnvelope {
+	return FaultEnvelope{
+		SOAPEnv: "http://schemas.xmlsoap.org/soap/envelope/",
+		Body: FaultBody{
+			Fault: Fault{
+				FaultCode:   faultCode,
+				FaultString: reason,
+				Detail:      detail,
+			},
+		},
+	}
 }
 
 // normalise parses a document, returning a document with only the request type's child nodes, stripped of prefix.
@@ -176,24 +175,19 @@ func stripNamespace(node *xmlquery.Node) {
 	}
 }
 
-// getKey returns the value for a child key from a node, if documented.
-func getKey(doc *xmlquery.Node, key string) (string, error) {
-	node := xmlquery.FindOne(doc, "//"+key)
-
-	if node == nil {
-		return "", errors.New("missing mandatory key named " + key)
-	} else {
-		return node.InnerText(), nil
-	}
+// page returns the bounds of the requested page within a list of the given length.
+func (l ListRequest) page(length int) (int, int) {
+	return paginate(length, int(l.ListResultOffset), int(l.ListResultLimit))
 }
 
-// Derived from https://stackoverflow.com/a/31832326, adding numbers
-const letterBytes = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-func RandString(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+// paginate returns the bounds of the requested page within a list of the given length.
+func paginate(length int, offset int, limit int) (int, int) {
+	if offset > length {
+		offset = length
+	}
+	end := length
+	if limit > 0 && offset+limit < length {
+		end = offset + limit
 	}
-	return string(b)
+	return offset, end
 }